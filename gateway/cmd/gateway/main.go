@@ -1,48 +1,47 @@
 package main
 
 import (
-	"log"
-	"net/http"
-
-	"github.com/RafaelZelak/gateway/internal/config"
-	"github.com/RafaelZelak/gateway/internal/jobs"
-	"github.com/RafaelZelak/gateway/internal/router"
-	"github.com/joho/godotenv"
+	"fmt"
+	"os"
+	"strings"
 )
 
-func main() {
-	// ensure external DNS resolution works (adds 8.8.8.8 if missing)
-	jobs.EnsureResolvConf()
+const usage = `Usage: gateway <command> [flags]
 
-	// initialize job scheduler
-	if err := jobs.InitJobScheduler(); err != nil {
-		log.Fatalf("Failed to init job scheduler: %v", err)
-	}
+Commands:
+  serve     start the gateway (default when no command is given)
+  validate  check the configuration and exit non-zero on problems
+  routes    print the resolved route table
 
-	// load .env if present
-	if err := godotenv.Load(); err != nil {
-		log.Printf(".env not found, relying on environment variables: %v", err)
-	}
+Run "gateway <command> -h" for the flags of each command.
+`
 
-	// load gateway configuration
-	cfg, err := config.LoadConfig("config.yml")
-	if err != nil {
-		log.Fatalf("Failed to load config: %v", err)
+func main() {
+	args := os.Args[1:]
+	cmd := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
 	}
 
-	// build HTTP router
-	mux, err := router.NewRouter(cfg)
-	if err != nil {
-		log.Fatalf("Failed to create router: %v", err)
+	switch cmd {
+	case "serve":
+		serve(args)
+	case "validate":
+		os.Exit(validate(args))
+	case "routes":
+		os.Exit(routes(args))
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", cmd, usage)
+		os.Exit(2)
 	}
+}
 
-	// start HTTP server
-	port := "8080"
-	if port == "" {
-		port = "80"
-	}
-	log.Printf("Starting server on port %s", port)
-	if err := http.ListenAndServe(":"+port, mux); err != nil {
-		log.Fatalf("Server failed: %v", err)
+// defaultConfigPath honours GATEWAY_CONFIG so containers can point at a conf.d directory.
+func defaultConfigPath() string {
+	if p := os.Getenv("GATEWAY_CONFIG"); p != "" {
+		return p
 	}
+	return "config.yml"
 }
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/RafaelZelak/gateway/internal/config"
+	"github.com/RafaelZelak/gateway/internal/router"
+	"github.com/joho/godotenv"
+)
+
+// routes prints the resolved route table. It returns the process exit code.
+func routes(args []string) int {
+	fs := flag.NewFlagSet("routes", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath(), "config file, conf.d directory or glob")
+	fs.Parse(args)
+
+	godotenv.Load()
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *configPath, err)
+		return 1
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOST\tROUTE\tMATCH\tKIND\tLOGIN\tMIDDLEWARE\tLOG\tTARGETS")
+	for _, r := range router.Describe(cfg) {
+		login := "-"
+		if r.Login {
+			login = "required"
+		}
+		host := r.Host
+		if host == "" {
+			host = "*"
+		}
+		route := r.Route
+		if r.Default {
+			route += " (default)"
+		}
+		match := r.Match
+		if r.Priority != 0 {
+			match = strings.TrimSpace(fmt.Sprintf("%s prio=%d", match, r.Priority))
+		}
+		if match == "" {
+			match = "-"
+		}
+		kind := r.Kind
+		if r.Strategy != "" {
+			kind += " [" + r.Strategy + "]"
+		}
+		chain := "-"
+		if len(r.Middleware) > 0 {
+			chain = strings.Join(r.Middleware, " > ")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", host, route, match, kind, login, chain, r.Log, strings.Join(r.Targets, ", "))
+	}
+	tw.Flush()
+	return 0
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/RafaelZelak/gateway/internal/jobs"
+	"github.com/RafaelZelak/gateway/internal/router"
+	"github.com/RafaelZelak/gateway/pkg/middleware"
+	"github.com/joho/godotenv"
+)
+
+// serve runs the gateway until the HTTP server fails.
+func serve(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath(), "config file, conf.d directory or glob")
+	jobsPath := fs.String("jobs", "jobs.yml", "jobs file")
+	listen := fs.String("listen", ":8080", "address to listen on")
+	fs.Parse(args)
+
+	// ensure external DNS resolution works (adds 8.8.8.8 if missing)
+	jobs.EnsureResolvConf()
+
+	// load .env if present (used by ${VAR} expansion in config.yml and jobs.yml)
+	if err := godotenv.Load(); err != nil {
+		log.Printf(".env not found, relying on environment variables: %v", err)
+	}
+
+	// initialize job scheduler
+	if err := jobs.InitJobScheduler(*jobsPath); err != nil {
+		log.Fatalf("Failed to init job scheduler: %v", err)
+	}
+
+	// load gateway configuration and build HTTP router
+	rl, err := router.NewReloader(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	// reload config on file change or SIGHUP, keeping the old router on error
+	go rl.Watch(2*time.Second, nil)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := rl.Reload(); err != nil {
+				log.Printf("[RELOAD] SIGHUP ignored, config is invalid: %v", err)
+				continue
+			}
+			log.Println("[RELOAD] Applied new config on SIGHUP")
+		}
+	}()
+
+	// start HTTP server
+	log.Printf("Starting server on %s", *listen)
+	if err := http.ListenAndServe(*listen, middleware.RequestID(rl)); err != nil {
+		log.Fatalf("Server failed: %v", err)
+	}
+}
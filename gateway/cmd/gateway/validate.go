@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/RafaelZelak/gateway/internal/config"
+	"github.com/joho/godotenv"
+)
+
+// validate loads the configuration, runs the environment checks and prints
+// every problem found. It returns the process exit code.
+func validate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath(), "config file, conf.d directory or glob")
+	asJSON := fs.Bool("json", false, "print problems as JSON")
+	fs.Parse(args)
+
+	godotenv.Load()
+
+	cfg, err := config.ParseConfig(*configPath)
+	if err != nil {
+		// nothing was loaded, so the error is not tied to a field
+		if *asJSON {
+			printJSON([]config.FieldError{{Index: -1, Source: *configPath, Message: err.Error()}})
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *configPath, err)
+		}
+		return 1
+	}
+
+	var problems []config.FieldError
+	if err := config.Validate(cfg); err != nil {
+		problems = append(problems, err.(config.ValidationError)...)
+	}
+	problems = append(problems, config.CheckEnvironment(cfg)...)
+
+	if *asJSON {
+		printJSON(problems)
+	} else if len(problems) == 0 {
+		fmt.Printf("%s: OK (%d services)\n", *configPath, len(cfg.Services))
+	} else {
+		for _, p := range problems {
+			fmt.Fprintln(os.Stderr, p.Error())
+		}
+	}
+
+	if len(problems) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// printJSON writes the validation result for --json.
+func printJSON(problems []config.FieldError) {
+	if problems == nil {
+		problems = []config.FieldError{}
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(map[string]interface{}{"valid": len(problems) == 0, "errors": problems})
+}
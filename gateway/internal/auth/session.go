@@ -7,6 +7,7 @@ import (
 	"path"
 	"time"
 
+	"github.com/RafaelZelak/gateway/pkg/httperr"
 	"github.com/golang-jwt/jwt/v5"
 )
 
@@ -24,7 +25,7 @@ func SessionMiddleware(baseRoute string, duration int) func(http.Handler) http.H
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			c, err := r.Cookie("session_token")
 			if err != nil {
-				http.Redirect(w, r, baseRoute+"/login", http.StatusSeeOther)
+				loginRequired(w, r, baseRoute)
 				return
 			}
 			claims := &Claims{}
@@ -32,7 +33,7 @@ func SessionMiddleware(baseRoute string, duration int) func(http.Handler) http.H
 				return jwtKey, nil
 			})
 			if err != nil || claims.Scope != baseRoute || claims.ExpiresAt.Time.Before(time.Now()) {
-				http.Redirect(w, r, baseRoute+"/login", http.StatusSeeOther)
+				loginRequired(w, r, baseRoute)
 				return
 			}
 			next.ServeHTTP(w, r)
@@ -40,6 +41,33 @@ func SessionMiddleware(baseRoute string, duration int) func(http.Handler) http.H
 	}
 }
 
+// loginRequired manda navegadores para a página de login; os demais clientes
+// (APIs, fetch) recebem 401, já que não seguiriam o redirect.
+func loginRequired(w http.ResponseWriter, r *http.Request, baseRoute string) {
+	if httperr.WantsHTML(r) {
+		http.Redirect(w, r, baseRoute+"/login", http.StatusSeeOther)
+		return
+	}
+	httperr.Write(w, r, http.StatusUnauthorized, httperr.CodeUnauthorized, "login required")
+}
+
+// Username devolve o usuário da sessão JWT da requisição, se ela for válida
+// e não tiver expirado, independentemente da rota que a emitiu.
+func Username(r *http.Request) (string, bool) {
+	c, err := r.Cookie("session_token")
+	if err != nil {
+		return "", false
+	}
+	claims := &Claims{}
+	_, err = jwt.ParseWithClaims(c.Value, claims, func(t *jwt.Token) (interface{}, error) {
+		return jwtKey, nil
+	})
+	if err != nil || claims.ExpiresAt == nil || claims.ExpiresAt.Time.Before(time.Now()) {
+		return "", false
+	}
+	return claims.Username, claims.Username != ""
+}
+
 // LoginHandler serve a página de login e gera o cookie JWT.
 func LoginHandler(baseRoute string, duration int) http.Handler {
 	// lê o template embarcado em internal/auth/templates/login.html
@@ -57,7 +85,7 @@ func LoginHandler(baseRoute string, duration int) http.Handler {
 		info, err := Authenticate(user, pass)
 		if err != nil {
 			log.Printf("LDAP auth failed for %q: %v", user, err)
-			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+			httperr.Write(w, r, http.StatusUnauthorized, httperr.CodeUnauthorized, "invalid credentials")
 			return
 		}
 
@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSessionMiddlewareWithoutSession(t *testing.T) {
+	h := SessionMiddleware("/app", 60)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request without a session reached the route")
+	}))
+	for _, tc := range []struct {
+		accept string
+		status int
+	}{
+		{"text/html,application/xhtml+xml", http.StatusSeeOther},
+		{"application/json", http.StatusUnauthorized},
+		{"", http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/app/data", nil)
+		req.Header.Set("Accept", tc.accept)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Errorf("Accept %q: status = %d, want %d", tc.accept, rec.Code, tc.status)
+		}
+		if tc.status == http.StatusUnauthorized && rec.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("Accept %q: Content-Type = %q, want the JSON envelope", tc.accept, rec.Header().Get("Content-Type"))
+		}
+	}
+}
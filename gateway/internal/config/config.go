@@ -2,57 +2,246 @@ package config
 
 import (
 	"fmt"
-	"net/url"
 	"os"
-
-	"gopkg.in/yaml.v3"
+	"path/filepath"
+	"sort"
+	"strings"
 )
 
 // ServiceConfig represents each entry in config.yml
 type ServiceConfig struct {
+	Host            string            `yaml:"host,omitempty"`
 	Route           string            `yaml:"route"`
+	Default         bool              `yaml:"default,omitempty"`
+	Match           *MatchConfig      `yaml:"match,omitempty"`
+	Priority        int               `yaml:"priority,omitempty"`
 	Target          string            `yaml:"target,omitempty"`
+	Targets         []Upstream        `yaml:"targets,omitempty"`
 	TemplateDir     string            `yaml:"templateDir,omitempty"`
 	TemplateRoutes  map[string]string `yaml:"templateRoutes,omitempty"`
 	Log             string            `yaml:"log,omitempty"`
+	ErrorPage       string            `yaml:"errorPage,omitempty"`
 	Login           bool              `yaml:"login,omitempty"`
 	SessionDuration int               `yaml:"session_duration,omitempty"`
+	Middleware      *MiddlewareConfig `yaml:"middleware,omitempty"`
+	StripPrefix     string            `yaml:"stripPrefix,omitempty"`
+	AddPrefix       string            `yaml:"addPrefix,omitempty"`
+	Rewrite         []RewriteRule     `yaml:"rewrite,omitempty"`
+	HealthCheck     *HealthCheck      `yaml:"healthCheck,omitempty"`
+	Outlier         *OutlierDetection `yaml:"outlierDetection,omitempty"`
+	LB              *LoadBalancing    `yaml:"lb,omitempty"`
+	Sticky          *StickySessions   `yaml:"sticky,omitempty"`
+	Retry           *Retry            `yaml:"retry,omitempty"`
+	CircuitBreaker  *CircuitBreaker   `yaml:"circuitBreaker,omitempty"`
+	Timeouts        *Timeouts         `yaml:"timeouts,omitempty"`
+	Cache           *Cache            `yaml:"cache,omitempty"`
+	Coalesce        *Coalesce         `yaml:"coalesce,omitempty"`
+	Split           *Split            `yaml:"split,omitempty"`
+	Mirror          *Mirror           `yaml:"mirror,omitempty"`
+	TLS             *TLS              `yaml:"tls,omitempty"`
+	WebSocket       *WebSocket        `yaml:"websocket,omitempty"`
+
+	// Source is the file the service was loaded from
+	Source string `yaml:"-"`
+}
+
+// RewriteRule replaces the part of the path matched by Regex; Replacement
+// may reference capture groups as $1 or, escaped from environment
+// expansion, as $${name}.
+type RewriteRule struct {
+	Regex       string `yaml:"regex"`
+	Replacement string `yaml:"replacement"`
+}
+
+// MiddlewareConfig declares the middleware chain applied around a route.
+// Unset fields inherit the global default; a zero value disables the step.
+type MiddlewareConfig struct {
+	CORS      *CORSConfig      `yaml:"cors,omitempty"`
+	RateLimit *RateLimitConfig `yaml:"rateLimit,omitempty"`
+	ConnLimit *int             `yaml:"connLimit,omitempty"`
+	Queue     *int             `yaml:"queue,omitempty"`
+}
+
+// CORSConfig lists what cross-origin callers may use. Empty lists fall back
+// to the same defaults as middleware.WrapMux.
+type CORSConfig struct {
+	Origins []string `yaml:"origins,omitempty"`
+	Methods []string `yaml:"methods,omitempty"`
+	Headers []string `yaml:"headers,omitempty"`
+}
+
+// RateLimitConfig is a per-client token bucket.
+type RateLimitConfig struct {
+	RPS   float64 `yaml:"rps"`
+	Burst int     `yaml:"burst"`
+}
+
+// AdminConfig exposes the gateway's internal state under Route. When Token
+// is set, every admin request must send it as a bearer token; without it
+// only read-only requests are served.
+type AdminConfig struct {
+	Route string `yaml:"route"`
+	Token string `yaml:"token,omitempty"`
+}
+
+// Patterns returns the ServeMux patterns the router registers for the admin
+// endpoints.
+func (a AdminConfig) Patterns() []string {
+	prefix := strings.TrimRight(a.Route, "/")
+	return []string{prefix + "/upstreams", prefix + "/metrics", prefix + "/cache/purge", prefix + "/split"}
+}
+
+// Patterns returns the ServeMux patterns the router registers for the
+// service: the route itself, its login pages and its static assets.
+func (svc ServiceConfig) Patterns() []string {
+	patterns := []string{svc.Route, svc.Route + "/"}
+	if svc.Login {
+		patterns = append(patterns, svc.Route+"/login", svc.Route+"/login/", svc.Route+"/logout", svc.Route+"/logout/")
+	}
+	if svc.TemplateDir != "" {
+		route := strings.TrimRight(svc.Route, "/")
+		patterns = append(patterns, route+"/styles/", route+"/scripts/")
+	}
+	return patterns
 }
 
 // Config holds all service configurations
 type Config struct {
-	Services []ServiceConfig `yaml:"services"`
+	Admin       *AdminConfig      `yaml:"admin,omitempty"`
+	Middleware  *MiddlewareConfig `yaml:"middleware,omitempty"`
+	RetryBudget *RetryBudget      `yaml:"retryBudget,omitempty"`
+	Services    []ServiceConfig   `yaml:"services"`
 }
 
-// LoadConfig reads, parses and validates the YAML configuration file
+// MiddlewareFor merges the service's middleware block over the global default.
+func (c *Config) MiddlewareFor(svc ServiceConfig) MiddlewareConfig {
+	var mw MiddlewareConfig
+	if c.Middleware != nil {
+		mw = *c.Middleware
+	}
+	if o := svc.Middleware; o != nil {
+		if o.CORS != nil {
+			mw.CORS = o.CORS
+		}
+		if o.RateLimit != nil {
+			mw.RateLimit = o.RateLimit
+		}
+		if o.ConnLimit != nil {
+			mw.ConnLimit = o.ConnLimit
+		}
+		if o.Queue != nil {
+			mw.Queue = o.Queue
+		}
+	}
+	return mw
+}
+
+// Sources resolves path into the list of YAML files to load. path may be a
+// single file, a directory (every *.yml and *.yaml inside it) or a glob such
+// as config.d/*.yml. Files are returned in lexical order.
+func Sources(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	switch {
+	case err == nil && !info.IsDir():
+		return []string{path}, nil
+	case err == nil:
+		var files []string
+		for _, pattern := range []string{"*.yml", "*.yaml"} {
+			matches, err := filepath.Glob(filepath.Join(path, pattern))
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, matches...)
+		}
+		sort.Strings(files)
+		return files, nil
+	}
+
+	matches, globErr := filepath.Glob(path)
+	if globErr != nil || len(matches) == 0 {
+		return nil, err
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// LoadConfig reads, expands, parses and validates the YAML configuration,
+// merging the services of every file found by Sources into one Config.
 func LoadConfig(path string) (*Config, error) {
-	// read file using os.ReadFile (deprecated ioutil.ReadFile removed)
-	data, err := os.ReadFile(path)
+	cfg, err := ParseConfig(path)
 	if err != nil {
 		return nil, err
 	}
+	if err := Validate(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
 
-	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
+// normalizeRoute drops trailing slashes, so /api and /api/ name the same
+// route; the router serves both forms either way.
+func normalizeRoute(route string) string {
+	if route == "" {
+		return ""
+	}
+	if trimmed := strings.TrimRight(route, "/"); trimmed != "" {
+		return trimmed
+	}
+	return "/"
+}
+
+// ParseConfig reads, expands and merges the configuration without validating it.
+func ParseConfig(path string) (*Config, error) {
+	files, err := Sources(path)
+	if err != nil {
 		return nil, err
 	}
 
-	// validate each service entry
-	for i, svc := range cfg.Services {
-		if svc.Route == "" {
-			return nil, fmt.Errorf("service %d: route is required", i)
+	var cfg Config
+	globals := make(map[string]string) // global setting -> file defining it
+	setGlobal := func(name, file string) error {
+		if prev, ok := globals[name]; ok {
+			return fmt.Errorf("%s: global %s already defined in %s", file, name, prev)
 		}
-		// require at least one of Target or TemplateDir
-		if svc.Target == "" && svc.TemplateDir == "" {
-			return nil, fmt.Errorf("service %q: either target or templateDir must be specified", svc.Route)
+		globals[name] = file
+		return nil
+	}
+	for _, file := range files {
+		// read file using os.ReadFile (deprecated ioutil.ReadFile removed)
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		var part Config
+		if err := Unmarshal(data, &part); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
 		}
-		// if Target is set, ensure it's a valid URL
-		if svc.Target != "" {
-			if _, err := url.ParseRequestURI(svc.Target); err != nil {
-				return nil, fmt.Errorf("service %q: invalid target URL %q: %v", svc.Route, svc.Target, err)
+		// global settings may only come from one file
+		if part.Middleware != nil {
+			if err := setGlobal("middleware", file); err != nil {
+				return nil, err
 			}
+			cfg.Middleware = part.Middleware
+		}
+		if part.Admin != nil {
+			if err := setGlobal("admin", file); err != nil {
+				return nil, err
+			}
+			cfg.Admin = part.Admin
+			cfg.Admin.Route = normalizeRoute(cfg.Admin.Route)
+		}
+		if part.RetryBudget != nil {
+			if err := setGlobal("retryBudget", file); err != nil {
+				return nil, err
+			}
+			cfg.RetryBudget = part.RetryBudget
+		}
+		for _, svc := range part.Services {
+			svc.Source = file
+			svc.Route = normalizeRoute(svc.Route)
+			cfg.Services = append(cfg.Services, svc)
 		}
 	}
-
 	return &cfg, nil
 }
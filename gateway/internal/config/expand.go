@@ -0,0 +1,134 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// placeholder matches ${VAR}, ${VAR:-default} and ${file:/path/to/secret},
+// as well as the escaped form $${...}, which stands for a literal ${...}
+var placeholder = regexp.MustCompile(`\$?\$\{([^}]*)\}`)
+
+// Unmarshal decodes YAML into out after expanding environment variables and
+// secret files in every scalar value.
+func Unmarshal(data []byte, out interface{}) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	if err := expandNode(&doc, ""); err != nil {
+		return err
+	}
+	return doc.Decode(out)
+}
+
+// expandNode walks the YAML tree, expanding placeholders in scalar values.
+// owner describes the enclosing list entry (e.g. service "/health") so
+// errors point at the entry that needs fixing.
+func expandNode(n *yaml.Node, owner string) error {
+	switch n.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, c := range n.Content {
+			if err := expandNode(c, owner); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		if name := entryName(n); name != "" {
+			owner = name
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, val := n.Content[i], n.Content[i+1]
+			if err := expandNode(val, owner); err != nil {
+				if fe, ok := err.(expandError); ok {
+					return fmt.Errorf("%s: field %s: %w", ownerOrRoot(owner), key.Value, fe.error)
+				}
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		v, err := expand(n.Value)
+		if err != nil {
+			return err
+		}
+		if v != n.Value && n.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle) == 0 {
+			// let unquoted values be re-resolved, so ${PORT} can fill an int field
+			n.Tag = ""
+		}
+		n.Value = v
+	}
+	return nil
+}
+
+// expandError is a scalar expansion error not yet annotated with its location.
+type expandError struct{ error }
+
+// entryName identifies a services/jobs list entry by its route or job name.
+func entryName(n *yaml.Node) string {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		switch n.Content[i].Value {
+		case "route":
+			return fmt.Sprintf("service %q", n.Content[i+1].Value)
+		case "job":
+			return fmt.Sprintf("job %q", n.Content[i+1].Value)
+		}
+	}
+	return ""
+}
+
+func ownerOrRoot(owner string) string {
+	if owner == "" {
+		return "config"
+	}
+	return owner
+}
+
+// expand replaces every placeholder in s.
+func expand(s string) (string, error) {
+	var firstErr error
+	out := placeholder.ReplaceAllStringFunc(s, func(m string) string {
+		if firstErr != nil {
+			return m
+		}
+		if strings.HasPrefix(m, "$$") {
+			return m[1:]
+		}
+		v, err := resolve(m[2 : len(m)-1])
+		if err != nil {
+			firstErr = err
+			return m
+		}
+		return v
+	})
+	if firstErr != nil {
+		return "", expandError{firstErr}
+	}
+	return out, nil
+}
+
+// resolve evaluates the body of a single placeholder.
+func resolve(expr string) (string, error) {
+	if path, ok := strings.CutPrefix(expr, "file:"); ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("secret file %q: %v", path, err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	name, def, hasDefault := strings.Cut(expr, ":-")
+	if name == "" {
+		return "", fmt.Errorf("empty variable name in ${%s}", expr)
+	}
+	if v, ok := os.LookupEnv(name); ok && v != "" {
+		return v, nil
+	}
+	if hasDefault {
+		return def, nil
+	}
+	return "", fmt.Errorf("environment variable %q is not set", name)
+}
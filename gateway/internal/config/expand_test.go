@@ -0,0 +1,57 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestLoadConfigNamedGroupRewrite(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("API_HOST", "api")
+	path := filepath.Join(dir, "config.yml")
+	yml := `services:
+  - route: /users
+    target: http://${API_HOST}:8000
+    rewrite:
+      - {regex: "^/users/(?P<id>[0-9]+)$", replacement: "/accounts/$${id}"}
+    log: ` + filepath.Join(dir, "users.log") + `
+`
+	if err := os.WriteFile(path, []byte(yml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	svc := cfg.Services[0]
+	if svc.Target != "http://api:8000" {
+		t.Errorf("target = %q, want the environment to be expanded", svc.Target)
+	}
+	rule := svc.Rewrite[0]
+	if rule.Replacement != "/accounts/${id}" {
+		t.Fatalf("replacement = %q, want /accounts/${id}", rule.Replacement)
+	}
+	got := regexp.MustCompile(rule.Regex).ReplaceAllString("/users/42", rule.Replacement)
+	if got != "/accounts/42" {
+		t.Errorf("rewrite gave %q, want /accounts/42", got)
+	}
+}
+
+func TestExpandEscape(t *testing.T) {
+	t.Setenv("NAME", "value")
+	for in, want := range map[string]string{
+		"${NAME}":          "value",
+		"$${NAME}":         "${NAME}",
+		"a$${x}b${NAME}":   "a${x}bvalue",
+		"$${missing:-def}": "${missing:-def}",
+		"cost: $$5":        "cost: $$5",
+	} {
+		got, err := expand(in)
+		if err != nil || got != want {
+			t.Errorf("expand(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+}
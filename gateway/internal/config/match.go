@@ -0,0 +1,127 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// MatchConfig restricts a service to requests with the given method,
+// headers, query parameters and cookies. Every listed predicate must hold.
+type MatchConfig struct {
+	Methods []string               `yaml:"methods,omitempty"`
+	Headers map[string]StringMatch `yaml:"headers,omitempty"`
+	Query   map[string]StringMatch `yaml:"query,omitempty"`
+	Cookies map[string]StringMatch `yaml:"cookies,omitempty"`
+}
+
+// StringMatch is written either as a plain value (exact match) or as
+// {regex: "..."}.
+type StringMatch struct {
+	Exact string
+	Regex string
+}
+
+// UnmarshalYAML accepts both the scalar and the {regex: ...} forms.
+func (m *StringMatch) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		m.Exact = n.Value
+		return nil
+	}
+	var aux struct {
+		Exact string `yaml:"exact"`
+		Regex string `yaml:"regex"`
+	}
+	if err := n.Decode(&aux); err != nil {
+		return err
+	}
+	if aux.Exact != "" && aux.Regex != "" {
+		return fmt.Errorf("line %d: use either exact or regex, not both", n.Line)
+	}
+	m.Exact, m.Regex = aux.Exact, aux.Regex
+	return nil
+}
+
+func (m StringMatch) String() string {
+	if m.Regex != "" {
+		return "~" + m.Regex
+	}
+	return m.Exact
+}
+
+// String renders the predicates compactly for the route table.
+func (m *MatchConfig) String() string {
+	if m == nil {
+		return ""
+	}
+	var parts []string
+	if len(m.Methods) > 0 {
+		parts = append(parts, strings.Join(m.Methods, ","))
+	}
+	for _, kv := range []struct {
+		kind   string
+		values map[string]StringMatch
+	}{{"header", m.Headers}, {"query", m.Query}, {"cookie", m.Cookies}} {
+		keys := make([]string, 0, len(kv.values))
+		for k := range kv.values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			parts = append(parts, fmt.Sprintf("%s:%s=%s", kv.kind, k, kv.values[k]))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// exclusive reports whether no request can satisfy both a and b, i.e. they
+// accept disjoint method sets or require different exact values for the
+// same header, query parameter or cookie.
+func exclusive(a, b *MatchConfig) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	if len(a.Methods) > 0 && len(b.Methods) > 0 {
+		shared := false
+		for _, x := range a.Methods {
+			for _, y := range b.Methods {
+				if strings.EqualFold(x, y) {
+					shared = true
+				}
+			}
+		}
+		if !shared {
+			return true
+		}
+	}
+	return conflicting(a.Headers, b.Headers, http.CanonicalHeaderKey) ||
+		conflicting(a.Query, b.Query, nil) ||
+		conflicting(a.Cookies, b.Cookies, nil)
+}
+
+// conflicting reports whether a and b require different exact values for a key.
+func conflicting(a, b map[string]StringMatch, canon func(string) string) bool {
+	if canon == nil {
+		canon = func(s string) string { return s }
+	}
+	exact := make(map[string]string, len(a))
+	for k, v := range a {
+		if v.Regex == "" {
+			exact[canon(k)] = v.Exact
+		}
+	}
+	for k, v := range b {
+		if x, ok := exact[canon(k)]; ok && v.Regex == "" && x != v.Exact {
+			return true
+		}
+	}
+	return false
+}
+
+// empty reports whether m accepts every request.
+func (m *MatchConfig) empty() bool {
+	return m == nil || len(m.Methods) == 0 && len(m.Headers) == 0 && len(m.Query) == 0 && len(m.Cookies) == 0
+}
@@ -0,0 +1,108 @@
+package config
+
+import "fmt"
+
+// Split divides the traffic of a service between upstream groups, e.g. a
+// stable release and a canary. Clients are assigned by hashing Key (the
+// logged-in user, falling back to the client IP, when empty); overrides pin
+// matching requests to a group.
+type Split struct {
+	Key       string          `yaml:"key,omitempty"`
+	Groups    []SplitGroup    `yaml:"groups"`
+	Overrides []SplitOverride `yaml:"overrides,omitempty"`
+}
+
+// SplitGroup is one version of the service with its share of the traffic.
+type SplitGroup struct {
+	Name    string     `yaml:"name"`
+	Weight  int        `yaml:"weight"`
+	Target  string     `yaml:"target,omitempty"`
+	Targets []Upstream `yaml:"targets,omitempty"`
+}
+
+// Upstreams returns the group backends, like ServiceConfig.Upstreams.
+func (g SplitGroup) Upstreams() []Upstream {
+	return upstreams(g.Target, g.Targets)
+}
+
+// SplitOverride sends requests matching Match, and/or made by one of Users
+// (the Username of the session JWT), to Group regardless of weights.
+type SplitOverride struct {
+	Group string       `yaml:"group"`
+	Match *MatchConfig `yaml:"match,omitempty"`
+	Users []string     `yaml:"users,omitempty"`
+}
+
+// Weights returns the configured weight of every group, in order.
+func (s *Split) Weights() []int {
+	out := make([]int, len(s.Groups))
+	for i, g := range s.Groups {
+		out[i] = g.Weight
+	}
+	return out
+}
+
+// validateSplit checks the groups and overrides of a split service.
+func validateSplit(i int, svc ServiceConfig) []FieldError {
+	sp := svc.Split
+	if sp == nil {
+		return nil
+	}
+	var errs []FieldError
+	if svc.Target != "" || len(svc.Targets) > 0 || svc.TemplateDir != "" {
+		errs = append(errs, fieldError(i, svc, "split", "cannot be combined with target, targets or templateDir"))
+	}
+	if err := ValidateHashKey(sp.Key); err != nil {
+		errs = append(errs, fieldError(i, svc, "split.key", "%v", err))
+	}
+	if len(sp.Groups) == 0 {
+		errs = append(errs, fieldError(i, svc, "split.groups", "at least one group is required"))
+	}
+
+	names := make(map[string]bool)
+	total := 0
+	for j, g := range sp.Groups {
+		prefix := fmt.Sprintf("split.groups[%d].", j)
+		switch {
+		case g.Name == "":
+			errs = append(errs, fieldError(i, svc, prefix+"name", "name is required"))
+		case names[g.Name]:
+			errs = append(errs, fieldError(i, svc, prefix+"name", "duplicate group name %q", g.Name))
+		}
+		names[g.Name] = true
+		if g.Weight < 0 {
+			errs = append(errs, fieldError(i, svc, prefix+"weight", "must not be negative"))
+		}
+		total += g.Weight
+
+		if g.Target == "" && len(g.Targets) == 0 {
+			errs = append(errs, fieldError(i, svc, prefix+"target", "either target or targets must be specified"))
+			continue
+		}
+		if g.Target != "" && len(g.Targets) > 0 {
+			errs = append(errs, fieldError(i, svc, prefix+"targets", "cannot be combined with the target shorthand"))
+		}
+		errs = append(errs, validateUpstreams(i, svc, prefix, g.Target, g.Targets)...)
+		for _, up := range g.Upstreams() {
+			if IsWebSocketURL(up.URL) {
+				errs = append(errs, fieldError(i, svc, prefix+"target", "WebSocket targets cannot be split"))
+				break
+			}
+		}
+	}
+	if len(sp.Groups) > 0 && total == 0 {
+		errs = append(errs, fieldError(i, svc, "split.groups", "at least one group needs a positive weight"))
+	}
+
+	for j, o := range sp.Overrides {
+		field := fmt.Sprintf("split.overrides[%d]", j)
+		if !names[o.Group] {
+			errs = append(errs, fieldError(i, svc, field+".group", "unknown group %q", o.Group))
+		}
+		if o.Match.empty() && len(o.Users) == 0 {
+			errs = append(errs, fieldError(i, svc, field, "needs match or users"))
+		}
+		errs = append(errs, validateMatch(i, svc, field+".match", o.Match)...)
+	}
+	return errs
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"os"
+)
+
+// TLS configures how the gateway connects to https:// and wss:// upstreams:
+// a CA bundle to trust instead of the system roots, a client certificate for
+// mutual TLS, the expected server name and the lowest protocol version.
+type TLS struct {
+	CA         string `yaml:"ca,omitempty"`
+	Cert       string `yaml:"cert,omitempty"`
+	Key        string `yaml:"key,omitempty"`
+	ServerName string `yaml:"serverName,omitempty"`
+	MinVersion string `yaml:"minVersion,omitempty"`
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ClientConfig loads the certificates and returns the client TLS settings.
+// A nil TLS yields a nil config, i.e. the Go defaults.
+func (t *TLS) ClientConfig() (*tls.Config, error) {
+	if t == nil {
+		return nil, nil
+	}
+	c := &tls.Config{ServerName: t.ServerName}
+	if t.MinVersion != "" {
+		v, ok := tlsVersions[t.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS version %q", t.MinVersion)
+		}
+		c.MinVersion = v
+	}
+	if err := t.load(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// load reads the CA bundle and client certificate into c.
+func (t *TLS) load(c *tls.Config) error {
+	if t.CA != "" {
+		pem, err := os.ReadFile(t.CA)
+		if err != nil {
+			return err
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", t.CA)
+		}
+	}
+	if t.Cert != "" && t.Key != "" {
+		cert, err := tls.LoadX509KeyPair(t.Cert, t.Key)
+		if err != nil {
+			return err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	return nil
+}
+
+// IsWebSocketURL reports whether target is a ws:// or wss:// URL.
+func IsWebSocketURL(target string) bool {
+	u, err := url.Parse(target)
+	return err == nil && (u.Scheme == "ws" || u.Scheme == "wss")
+}
+
+// validateTLS checks the settings that can be verified without reading the
+// certificate files; CheckEnvironment loads them.
+func validateTLS(i int, svc ServiceConfig) []FieldError {
+	t := svc.TLS
+	if t == nil {
+		return nil
+	}
+	var errs []FieldError
+	if svc.TemplateDir != "" {
+		errs = append(errs, fieldError(i, svc, "tls", "only applies to proxied services"))
+	}
+	if (t.Cert == "") != (t.Key == "") {
+		errs = append(errs, fieldError(i, svc, "tls.cert", "cert and key must be set together"))
+	}
+	if _, ok := tlsVersions[t.MinVersion]; t.MinVersion != "" && !ok {
+		errs = append(errs, fieldError(i, svc, "tls.minVersion", "must be one of 1.0, 1.1, 1.2 or 1.3"))
+	}
+
+	ups := svc.Upstreams()
+	if svc.Split != nil {
+		for _, g := range svc.Split.Groups {
+			ups = append(ups, g.Upstreams()...)
+		}
+	}
+	secure := false
+	for _, up := range ups {
+		if u, err := url.Parse(up.URL); err == nil && (u.Scheme == "https" || u.Scheme == "wss") {
+			secure = true
+		}
+	}
+	if svc.TemplateDir == "" && !secure {
+		errs = append(errs, fieldError(i, svc, "tls", "has no effect without https:// or wss:// targets"))
+	}
+	return errs
+}
@@ -0,0 +1,376 @@
+package config
+
+import (
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Upstream is one backend of a proxied service.
+type Upstream struct {
+	Name     string `yaml:"name,omitempty"`
+	URL      string `yaml:"url"`
+	Weight   *int   `yaml:"weight,omitempty"`
+	MaxConns int    `yaml:"maxConns,omitempty"`
+	Backup   bool   `yaml:"backup,omitempty"`
+}
+
+// Upstreams returns the service backends, taken from the targets list or
+// from the comma-separated target shorthand. Missing names default to the
+// URL host and missing weights to 1.
+func (s ServiceConfig) Upstreams() []Upstream {
+	return upstreams(s.Target, s.Targets)
+}
+
+func upstreams(target string, targets []Upstream) []Upstream {
+	ups := make([]Upstream, 0, len(targets))
+	if len(targets) > 0 {
+		ups = append(ups, targets...)
+	} else {
+		for _, t := range strings.Split(target, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				ups = append(ups, Upstream{URL: t})
+			}
+		}
+	}
+	for i := range ups {
+		ups[i].URL = strings.TrimSpace(ups[i].URL)
+		if ups[i].Name == "" {
+			ups[i].Name = ups[i].URL
+			if u, err := url.Parse(ups[i].URL); err == nil && u.Host != "" {
+				ups[i].Name = u.Host
+			}
+		}
+		if ups[i].Weight == nil {
+			one := 1
+			ups[i].Weight = &one
+		}
+	}
+	return ups
+}
+
+// HealthCheck configures active probing of a service's backends.
+type HealthCheck struct {
+	Path               string        `yaml:"path"`
+	Interval           time.Duration `yaml:"interval,omitempty"`
+	Timeout            time.Duration `yaml:"timeout,omitempty"`
+	ExpectedStatus     int           `yaml:"expectedStatus,omitempty"`
+	HealthyThreshold   int           `yaml:"healthyThreshold,omitempty"`
+	UnhealthyThreshold int           `yaml:"unhealthyThreshold,omitempty"`
+}
+
+// WithDefaults fills unset fields: every 10s, 2s timeout, any 2xx/3xx
+// status, 2 successes to recover and 3 failures to go down.
+func (hc HealthCheck) WithDefaults() HealthCheck {
+	if hc.Path == "" {
+		hc.Path = "/"
+	}
+	if hc.Interval <= 0 {
+		hc.Interval = 10 * time.Second
+	}
+	if hc.Timeout <= 0 {
+		hc.Timeout = 2 * time.Second
+	}
+	if hc.HealthyThreshold <= 0 {
+		hc.HealthyThreshold = 2
+	}
+	if hc.UnhealthyThreshold <= 0 {
+		hc.UnhealthyThreshold = 3
+	}
+	return hc
+}
+
+// OutlierDetection configures passive ejection of backends based on the
+// responses of real traffic.
+type OutlierDetection struct {
+	ConsecutiveErrors  int           `yaml:"consecutiveErrors,omitempty"`
+	ErrorRate          float64       `yaml:"errorRate,omitempty"`
+	Window             time.Duration `yaml:"window,omitempty"`
+	MinRequests        int           `yaml:"minRequests,omitempty"`
+	BaseEjection       time.Duration `yaml:"baseEjection,omitempty"`
+	MaxEjection        time.Duration `yaml:"maxEjection,omitempty"`
+	MaxEjectionPercent int           `yaml:"maxEjectionPercent,omitempty"`
+}
+
+// WithDefaults fills unset fields: eject after 5 consecutive errors for 30s
+// (growing up to 5m), rate window of 10s with at least 10 requests, and at
+// most half of the backends ejected at once. ErrorRate 0 disables the rate check.
+func (od OutlierDetection) WithDefaults() OutlierDetection {
+	if od.ConsecutiveErrors <= 0 {
+		od.ConsecutiveErrors = 5
+	}
+	if od.Window <= 0 {
+		od.Window = 10 * time.Second
+	}
+	if od.MinRequests <= 0 {
+		od.MinRequests = 10
+	}
+	if od.BaseEjection <= 0 {
+		od.BaseEjection = 30 * time.Second
+	}
+	if od.MaxEjection <= 0 {
+		od.MaxEjection = 5 * time.Minute
+	}
+	if od.MaxEjectionPercent <= 0 {
+		od.MaxEjectionPercent = 50
+	}
+	return od
+}
+
+// Load-balancing strategies accepted in lb.strategy.
+const (
+	StrategyRandom             = "random"
+	StrategyRoundRobin         = "roundRobin"
+	StrategyWeightedRoundRobin = "weightedRoundRobin"
+	StrategyLeastRequests      = "leastRequests"
+	StrategyP2C                = "p2c"
+	StrategyHash               = "hash"
+)
+
+// LoadBalancing selects how a backend is picked. HashKey applies to the
+// hash strategy and is one of "ip", "path", "header:<name>" or "cookie:<name>".
+type LoadBalancing struct {
+	Strategy string `yaml:"strategy"`
+	HashKey  string `yaml:"hashKey,omitempty"`
+}
+
+// UnmarshalYAML also accepts the shorthand `lb: roundRobin`.
+func (lb *LoadBalancing) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		lb.Strategy = n.Value
+		return nil
+	}
+	type plain LoadBalancing
+	return n.Decode((*plain)(lb))
+}
+
+// StickySessions pins each client to one backend with a signed cookie.
+// Without a secret, a random per-process key is used, so pins do not
+// survive a restart.
+type StickySessions struct {
+	Cookie string        `yaml:"cookie,omitempty"`
+	TTL    time.Duration `yaml:"ttl,omitempty"`
+	Secret string        `yaml:"secret,omitempty"`
+}
+
+// Timeouts bounds the time spent on an upstream: Connect covers dialing the
+// backend, ResponseHeader the wait for its response headers once the request
+// is sent, Request the whole exchange including the body, and Idle how long
+// an unused keep-alive connection to the backend is kept open.
+type Timeouts struct {
+	Connect        time.Duration `yaml:"connect,omitempty"`
+	ResponseHeader time.Duration `yaml:"responseHeader,omitempty"`
+	Request        time.Duration `yaml:"request,omitempty"`
+	Idle           time.Duration `yaml:"idle,omitempty"`
+}
+
+// CircuitBreaker stops sending traffic to a backend after FailureThreshold
+// consecutive failures. Once OpenTimeout has passed, up to HalfOpenRequests
+// concurrent probes are let through: SuccessThreshold successful probes close
+// the circuit again, a failed one reopens it.
+type CircuitBreaker struct {
+	FailureThreshold int           `yaml:"failureThreshold,omitempty"`
+	OpenTimeout      time.Duration `yaml:"openTimeout,omitempty"`
+	HalfOpenRequests int           `yaml:"halfOpenRequests,omitempty"`
+	SuccessThreshold int           `yaml:"successThreshold,omitempty"`
+}
+
+// WithDefaults fills unset fields: open after 5 failures for 30s, then
+// close after 1 successful probe at a time.
+func (cb CircuitBreaker) WithDefaults() CircuitBreaker {
+	if cb.FailureThreshold <= 0 {
+		cb.FailureThreshold = 5
+	}
+	if cb.OpenTimeout <= 0 {
+		cb.OpenTimeout = 30 * time.Second
+	}
+	if cb.HalfOpenRequests <= 0 {
+		cb.HalfOpenRequests = 1
+	}
+	if cb.SuccessThreshold <= 0 {
+		cb.SuccessThreshold = 1
+	}
+	return cb
+}
+
+// Retry re-sends a failed request to another backend of the pool. Attempts
+// counts the first try. Idempotent requests are retried on a transport error
+// or a RetryOn status; other methods only when the request never reached the
+// backend. Bodies larger than MaxBodySize are not buffered and never retried.
+type Retry struct {
+	Attempts    int           `yaml:"attempts,omitempty"`
+	Backoff     time.Duration `yaml:"backoff,omitempty"`
+	MaxBackoff  time.Duration `yaml:"maxBackoff,omitempty"`
+	RetryOn     []int         `yaml:"retryOn,omitempty"`
+	MaxBodySize int           `yaml:"maxBodySize,omitempty"`
+}
+
+// WithDefaults fills unset fields: 3 attempts, backoff from 25ms up to 1s,
+// retry on 502/503/504 and buffer bodies up to 64KiB. An explicit empty
+// retryOn list only retries transport errors.
+func (rt Retry) WithDefaults() Retry {
+	if rt.Attempts <= 0 {
+		rt.Attempts = 3
+	}
+	if rt.Backoff <= 0 {
+		rt.Backoff = 25 * time.Millisecond
+	}
+	if rt.MaxBackoff <= 0 {
+		rt.MaxBackoff = time.Second
+	}
+	if rt.RetryOn == nil {
+		rt.RetryOn = []int{502, 503, 504}
+	}
+	if rt.MaxBodySize <= 0 {
+		rt.MaxBodySize = 64 << 10
+	}
+	return rt
+}
+
+// RetryBudget limits retries across the whole gateway: within Window, at
+// most Ratio retries per request plus MinRetries are allowed.
+type RetryBudget struct {
+	Ratio      float64       `yaml:"ratio,omitempty"`
+	MinRetries int           `yaml:"minRetries,omitempty"`
+	Window     time.Duration `yaml:"window,omitempty"`
+}
+
+// WithDefaults fills unset fields: 20% of requests, at least 10 retries per
+// 10s window.
+func (rb RetryBudget) WithDefaults() RetryBudget {
+	if rb.Ratio <= 0 {
+		rb.Ratio = 0.2
+	}
+	if rb.MinRetries <= 0 {
+		rb.MinRetries = 10
+	}
+	if rb.Window <= 0 {
+		rb.Window = 10 * time.Second
+	}
+	return rb
+}
+
+// Cache keeps GET responses of a service in a bounded in-memory LRU.
+// DefaultTTL applies to responses without freshness information;
+// StaleWhileRevalidate and StaleIfError are used when the response does not
+// set the directives of the same name.
+type Cache struct {
+	MaxEntries           int           `yaml:"maxEntries,omitempty"`
+	MaxBytes             int           `yaml:"maxBytes,omitempty"`
+	MaxEntryBytes        int           `yaml:"maxEntryBytes,omitempty"`
+	DefaultTTL           time.Duration `yaml:"defaultTTL,omitempty"`
+	StaleWhileRevalidate time.Duration `yaml:"staleWhileRevalidate,omitempty"`
+	StaleIfError         time.Duration `yaml:"staleIfError,omitempty"`
+}
+
+// WithDefaults fills unset limits: 1000 entries, 64MiB in total and 1MiB
+// per response.
+func (c Cache) WithDefaults() Cache {
+	if c.MaxEntries <= 0 {
+		c.MaxEntries = 1000
+	}
+	if c.MaxBytes <= 0 {
+		c.MaxBytes = 64 << 20
+	}
+	if c.MaxEntryBytes <= 0 {
+		c.MaxEntryBytes = 1 << 20
+	}
+	return c
+}
+
+// Coalesce merges identical concurrent GET and HEAD requests into one
+// upstream call. Waiting requests give up after MaxWait and responses larger
+// than MaxBodyBytes are not shared; both then go upstream on their own.
+type Coalesce struct {
+	MaxWait      time.Duration `yaml:"maxWait,omitempty"`
+	MaxBodyBytes int           `yaml:"maxBodyBytes,omitempty"`
+}
+
+// WithDefaults fills unset fields: wait up to 5s, share up to 1MiB.
+func (c Coalesce) WithDefaults() Coalesce {
+	if c.MaxWait <= 0 {
+		c.MaxWait = 5 * time.Second
+	}
+	if c.MaxBodyBytes <= 0 {
+		c.MaxBodyBytes = 1 << 20
+	}
+	return c
+}
+
+// Mirror sends a copy of a sample of the requests to a second backend and
+// discards its responses. Bodies larger than MaxBodyBytes are not mirrored;
+// when MaxConcurrent copies are in flight, further ones are dropped.
+type Mirror struct {
+	Target        string        `yaml:"target"`
+	Sample        *float64      `yaml:"sample,omitempty"`
+	MaxBodyBytes  int           `yaml:"maxBodyBytes,omitempty"`
+	Timeout       time.Duration `yaml:"timeout,omitempty"`
+	MaxConcurrent int           `yaml:"maxConcurrent,omitempty"`
+	Log           string        `yaml:"log,omitempty"`
+}
+
+// WithDefaults fills unset fields: mirror every request, bodies up to
+// 64KiB, 5s timeout and 16 copies in flight.
+func (m Mirror) WithDefaults() Mirror {
+	if m.Sample == nil {
+		all := 1.0
+		m.Sample = &all
+	}
+	if m.MaxBodyBytes <= 0 {
+		m.MaxBodyBytes = 64 << 10
+	}
+	if m.Timeout <= 0 {
+		m.Timeout = 5 * time.Second
+	}
+	if m.MaxConcurrent <= 0 {
+		m.MaxConcurrent = 16
+	}
+	return m
+}
+
+// MirrorLog returns the file mirror results are written to: Mirror.Log, or
+// the service log with a .mirror suffix (logs/api.log -> logs/api.mirror.log).
+func (s ServiceConfig) MirrorLog() string {
+	if s.Mirror.Log != "" {
+		return s.Mirror.Log
+	}
+	ext := filepath.Ext(s.Log)
+	return strings.TrimSuffix(s.Log, ext) + ".mirror" + ext
+}
+
+// WebSocket tunes the proxying of WebSocket services. ForwardHeaders lists
+// the client handshake headers passed on to the backend; nil forwards
+// Cookie, Authorization and User-Agent. The keepalive and size limits apply
+// to both the client and the backend connection.
+type WebSocket struct {
+	ForwardHeaders []string      `yaml:"forwardHeaders,omitempty"`
+	PingInterval   time.Duration `yaml:"pingInterval,omitempty"`
+	PongTimeout    time.Duration `yaml:"pongTimeout,omitempty"`
+	IdleTimeout    time.Duration `yaml:"idleTimeout,omitempty"`
+	MaxMessageSize int64         `yaml:"maxMessageSize,omitempty"`
+	WriteTimeout   time.Duration `yaml:"writeTimeout,omitempty"`
+}
+
+// WithDefaults fills unset fields: pings every 30s answered within 10s,
+// 1MiB messages and a 10s write timeout. IdleTimeout stays off unless set.
+func (ws WebSocket) WithDefaults() WebSocket {
+	if ws.ForwardHeaders == nil {
+		ws.ForwardHeaders = []string{"Cookie", "Authorization", "User-Agent"}
+	}
+	if ws.PingInterval <= 0 {
+		ws.PingInterval = 30 * time.Second
+	}
+	if ws.PongTimeout <= 0 {
+		ws.PongTimeout = 10 * time.Second
+	}
+	if ws.MaxMessageSize <= 0 {
+		ws.MaxMessageSize = 1 << 20
+	}
+	if ws.WriteTimeout <= 0 {
+		ws.WriteTimeout = 10 * time.Second
+	}
+	return ws
+}
@@ -0,0 +1,730 @@
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// FieldError describes a problem with one field of a service entry. Index
+// is -1 for global settings.
+type FieldError struct {
+	Index   int    `json:"index"`
+	Route   string `json:"route,omitempty"`
+	Source  string `json:"source,omitempty"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	if e.Index < 0 {
+		// global setting, not tied to a service
+		return fmt.Sprintf("%s: %s", e.Field, e.Message)
+	}
+	msg := fmt.Sprintf("services[%d]", e.Index)
+	if e.Route != "" {
+		msg += fmt.Sprintf(" (%s)", e.Route)
+	}
+	msg += fmt.Sprintf(".%s: %s", e.Field, e.Message)
+	if e.Source != "" {
+		msg = e.Source + ": " + msg
+	}
+	return msg
+}
+
+// ValidationError collects every problem found in a configuration.
+type ValidationError []FieldError
+
+func (ve ValidationError) Error() string {
+	if len(ve) == 1 {
+		return ve[0].Error()
+	}
+	lines := make([]string, len(ve))
+	for i, fe := range ve {
+		lines[i] = "  " + fe.Error()
+	}
+	return fmt.Sprintf("%d configuration problems:\n%s", len(ve), strings.Join(lines, "\n"))
+}
+
+// fieldError builds a FieldError for service i.
+func fieldError(i int, svc ServiceConfig, field, format string, args ...interface{}) FieldError {
+	return FieldError{
+		Index:   i,
+		Route:   svc.Route,
+		Source:  svc.Source,
+		Field:   field,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// Validate checks every service entry and returns a ValidationError listing
+// all problems found, or nil if the configuration is usable.
+func Validate(cfg *Config) error {
+	var errs ValidationError
+	groups := make(map[string][]int) // host + route -> services sharing the path
+	var order []string
+	defaults := make(map[string]int) // host -> index of its default service
+
+	for i, svc := range cfg.Services {
+		switch {
+		case svc.Route == "":
+			errs = append(errs, fieldError(i, svc, "route", "route is required"))
+		case !strings.HasPrefix(svc.Route, "/"):
+			errs = append(errs, fieldError(i, svc, "route", "route must start with \"/\""))
+		}
+		host := strings.ToLower(svc.Host)
+		if strings.HasPrefix(svc.Route, "/") {
+			key := host + normalizeRoute(svc.Route)
+			if _, ok := groups[key]; !ok {
+				order = append(order, key)
+			}
+			groups[key] = append(groups[key], i)
+		}
+		errs = append(errs, validateMatch(i, svc, "match", svc.Match)...)
+		if svc.Host != "" && !validHost(svc.Host) {
+			errs = append(errs, fieldError(i, svc, "host", "%q is not a host name or *.domain wildcard", svc.Host))
+		}
+		if svc.Default {
+			if j, ok := defaults[host]; ok {
+				errs = append(errs, fieldError(i, svc, "default", "host already has a default route (services[%d])", j))
+			} else {
+				defaults[host] = i
+			}
+		}
+
+		// require at least one of Target(s), TemplateDir or Split
+		if svc.Target == "" && len(svc.Targets) == 0 && svc.TemplateDir == "" && svc.Split == nil {
+			errs = append(errs, fieldError(i, svc, "target", "either target, targets, split or templateDir must be specified"))
+		}
+		if svc.Target != "" && len(svc.Targets) > 0 {
+			errs = append(errs, fieldError(i, svc, "targets", "cannot be combined with the target shorthand"))
+		}
+		errs = append(errs, validateTargets(i, svc)...)
+		errs = append(errs, validateSplit(i, svc)...)
+		errs = append(errs, validateTLS(i, svc)...)
+
+		errs = append(errs, validateMiddleware(i, svc, cfg.MiddlewareFor(svc))...)
+		errs = append(errs, validateRewrite(i, svc)...)
+		errs = append(errs, validateHealthCheck(i, svc)...)
+		errs = append(errs, validateOutlier(i, svc)...)
+		errs = append(errs, validateLB(i, svc)...)
+		errs = append(errs, validateRetry(i, svc)...)
+		if cb := svc.CircuitBreaker; cb != nil {
+			if svc.TemplateDir != "" || handlerIsWebSocket(svc) {
+				errs = append(errs, fieldError(i, svc, "circuitBreaker", "only applies to HTTP proxied services"))
+			}
+			if cb.FailureThreshold < 0 || cb.OpenTimeout < 0 || cb.HalfOpenRequests < 0 || cb.SuccessThreshold < 0 {
+				errs = append(errs, fieldError(i, svc, "circuitBreaker", "values must not be negative"))
+			}
+		}
+		if c := svc.Cache; c != nil {
+			if svc.TemplateDir != "" || handlerIsWebSocket(svc) {
+				errs = append(errs, fieldError(i, svc, "cache", "only applies to HTTP proxied services"))
+			}
+			if why := perClientBackend(svc); why != "" {
+				errs = append(errs, fieldError(i, svc, "cache", "cannot be combined with %s: cached responses would reach clients assigned to another backend", why))
+			}
+			if c.MaxEntries < 0 || c.MaxBytes < 0 || c.MaxEntryBytes < 0 || c.DefaultTTL < 0 || c.StaleWhileRevalidate < 0 || c.StaleIfError < 0 {
+				errs = append(errs, fieldError(i, svc, "cache", "values must not be negative"))
+			}
+			if c.MaxBytes > 0 && c.MaxEntryBytes > c.MaxBytes {
+				errs = append(errs, fieldError(i, svc, "cache.maxEntryBytes", "must not exceed maxBytes"))
+			}
+		}
+		if ws := svc.WebSocket; ws != nil {
+			if !handlerIsWebSocket(svc) {
+				errs = append(errs, fieldError(i, svc, "websocket", "only applies to WebSocket services"))
+			}
+			for j, h := range ws.ForwardHeaders {
+				switch http.CanonicalHeaderKey(h) {
+				case "":
+					errs = append(errs, fieldError(i, svc, fmt.Sprintf("websocket.forwardHeaders[%d]", j), "must not be empty"))
+				case "Upgrade", "Connection", "Sec-Websocket-Key", "Sec-Websocket-Version",
+					"Sec-Websocket-Extensions", "Sec-Websocket-Protocol":
+					errs = append(errs, fieldError(i, svc, fmt.Sprintf("websocket.forwardHeaders[%d]", j), "%q is set by the handshake and cannot be forwarded", h))
+				}
+			}
+			if ws.PingInterval < 0 || ws.PongTimeout < 0 || ws.IdleTimeout < 0 || ws.MaxMessageSize < 0 || ws.WriteTimeout < 0 {
+				errs = append(errs, fieldError(i, svc, "websocket", "values must not be negative"))
+			}
+		}
+		if m := svc.Mirror; m != nil {
+			if svc.TemplateDir != "" || handlerIsWebSocket(svc) {
+				errs = append(errs, fieldError(i, svc, "mirror", "only applies to HTTP proxied services"))
+			}
+			if u, err := url.ParseRequestURI(m.Target); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				errs = append(errs, fieldError(i, svc, "mirror.target", "%q is not an http(s) URL", m.Target))
+			}
+			if m.Sample != nil && (*m.Sample < 0 || *m.Sample > 1) {
+				errs = append(errs, fieldError(i, svc, "mirror.sample", "must be between 0 and 1"))
+			}
+			if m.MaxBodyBytes < 0 || m.Timeout < 0 || m.MaxConcurrent < 0 {
+				errs = append(errs, fieldError(i, svc, "mirror", "values must not be negative"))
+			}
+		}
+		if c := svc.Coalesce; c != nil {
+			if svc.TemplateDir != "" || handlerIsWebSocket(svc) {
+				errs = append(errs, fieldError(i, svc, "coalesce", "only applies to HTTP proxied services"))
+			}
+			if why := perClientBackend(svc); why != "" {
+				errs = append(errs, fieldError(i, svc, "coalesce", "cannot be combined with %s: merged responses would reach clients assigned to another backend", why))
+			}
+			if c.MaxWait < 0 || c.MaxBodyBytes < 0 {
+				errs = append(errs, fieldError(i, svc, "coalesce", "values must not be negative"))
+			}
+		}
+		if t := svc.Timeouts; t != nil {
+			if svc.TemplateDir != "" || handlerIsWebSocket(svc) {
+				errs = append(errs, fieldError(i, svc, "timeouts", "only applies to HTTP proxied services"))
+			}
+			if t.Connect < 0 || t.ResponseHeader < 0 || t.Request < 0 || t.Idle < 0 {
+				errs = append(errs, fieldError(i, svc, "timeouts", "values must not be negative"))
+			}
+		}
+		if st := svc.Sticky; st != nil {
+			if svc.TemplateDir != "" || handlerIsWebSocket(svc) {
+				errs = append(errs, fieldError(i, svc, "sticky", "only applies to HTTP proxied services"))
+			}
+			if st.TTL < 0 {
+				errs = append(errs, fieldError(i, svc, "sticky.ttl", "must not be negative"))
+			}
+			if st.Cookie != "" && strings.ContainsAny(st.Cookie, " ;,=\t") {
+				errs = append(errs, fieldError(i, svc, "sticky.cookie", "%q is not a valid cookie name", st.Cookie))
+			}
+		}
+
+		if svc.ErrorPage != "" {
+			if _, err := template.ParseFiles(svc.ErrorPage); err != nil {
+				errs = append(errs, fieldError(i, svc, "errorPage", "%v", err))
+			}
+		}
+
+		if svc.Login && svc.SessionDuration <= 0 {
+			errs = append(errs, fieldError(i, svc, "session_duration", "must be a positive number of seconds when login is enabled"))
+		}
+
+		// templateRoutes must point at templates that exist; a missing
+		// templateDir itself is reported by CheckEnvironment
+		if info, err := os.Stat(svc.TemplateDir); err == nil && info.IsDir() {
+			aliases := make([]string, 0, len(svc.TemplateRoutes))
+			for alias := range svc.TemplateRoutes {
+				aliases = append(aliases, alias)
+			}
+			sort.Strings(aliases)
+			for _, alias := range aliases {
+				file := svc.TemplateRoutes[alias]
+				if _, err := os.Stat(filepath.Join(svc.TemplateDir, file)); err != nil {
+					errs = append(errs, fieldError(i, svc, "templateRoutes."+alias, "template %q not found in %s", file, svc.TemplateDir))
+				}
+			}
+		} else if len(svc.TemplateRoutes) > 0 && svc.TemplateDir == "" {
+			errs = append(errs, fieldError(i, svc, "templateRoutes", "requires templateDir"))
+		}
+	}
+
+	for _, key := range order {
+		errs = append(errs, validateShared(cfg, groups[key])...)
+	}
+	errs = append(errs, validateRoutes(cfg)...)
+	if cfg.Admin != nil && !strings.HasPrefix(cfg.Admin.Route, "/") {
+		errs = append(errs, FieldError{Index: -1, Field: "admin.route", Message: "must start with \"/\""})
+	}
+	if rb := cfg.RetryBudget; rb != nil {
+		if rb.Ratio < 0 || rb.Ratio > 1 {
+			errs = append(errs, FieldError{Index: -1, Field: "retryBudget.ratio", Message: "must be between 0 and 1"})
+		}
+		if rb.MinRetries < 0 || rb.Window < 0 {
+			errs = append(errs, FieldError{Index: -1, Field: "retryBudget", Message: "values must not be negative"})
+		}
+	}
+
+	if len(errs) > 0 {
+		sort.SliceStable(errs, func(a, b int) bool { return errs[a].Index < errs[b].Index })
+		return errs
+	}
+	return nil
+}
+
+// validateMatch checks a set of request predicates of a service.
+func validateMatch(i int, svc ServiceConfig, field string, m *MatchConfig) []FieldError {
+	var errs []FieldError
+	if m == nil {
+		return nil
+	}
+	for j, method := range m.Methods {
+		if method == "" || strings.ToUpper(method) != method || strings.ContainsAny(method, " \t") {
+			errs = append(errs, fieldError(i, svc, fmt.Sprintf("%s.methods[%d]", field, j), "%q is not an upper-case HTTP method", method))
+		}
+	}
+	for _, kv := range []struct {
+		field  string
+		values map[string]StringMatch
+	}{{"headers", m.Headers}, {"query", m.Query}, {"cookies", m.Cookies}} {
+		keys := make([]string, 0, len(kv.values))
+		for k := range kv.values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if re := kv.values[k].Regex; re != "" {
+				if _, err := regexp.Compile(re); err != nil {
+					errs = append(errs, fieldError(i, svc, field+"."+kv.field+"."+k, "invalid regex: %v", err))
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// validateShared checks services that share a host and route: they must be
+// told apart by priority or by mutually exclusive predicates, and none may
+// be shadowed by a higher-priority service that matches every request.
+func validateShared(cfg *Config, idx []int) []FieldError {
+	if len(idx) < 2 {
+		return nil
+	}
+	var errs []FieldError
+	for _, i := range idx {
+		if svc := cfg.Services[i]; svc.TemplateDir != "" {
+			errs = append(errs, fieldError(i, svc, "route", "template services cannot share a route with other services"))
+		}
+	}
+	for a, i := range idx {
+		for _, j := range idx[a+1:] {
+			si, sj := cfg.Services[i], cfg.Services[j]
+			switch {
+			case si.Priority == sj.Priority && si.Match.empty() && sj.Match.empty():
+				errs = append(errs, fieldError(j, sj, "route", "duplicate route, already defined by services[%d] in %s", i, si.Source))
+			case si.Priority == sj.Priority && !exclusive(si.Match, sj.Match):
+				errs = append(errs, fieldError(j, sj, "match", "ambiguous with services[%d]: same priority and overlapping predicates", i))
+			case si.Priority > sj.Priority && si.Match.empty():
+				errs = append(errs, fieldError(j, sj, "priority", "unreachable, services[%d] has higher priority and matches every request", i))
+			case sj.Priority > si.Priority && sj.Match.empty():
+				errs = append(errs, fieldError(i, si, "priority", "unreachable, services[%d] has higher priority and matches every request", j))
+			}
+		}
+	}
+	return errs
+}
+
+// validateRoutes registers every pattern the router registers (routes,
+// login pages, template assets, admin endpoints) on a scratch ServeMux per
+// host, so a pattern ServeMux rejects or one that conflicts with another is
+// reported here instead of failing when the router is built.
+func validateRoutes(cfg *Config) []FieldError {
+	type owner struct {
+		index    int // -1 for the admin endpoints
+		route    string
+		patterns []string
+	}
+	var errs []FieldError
+	accepted := make(map[string][]owner) // host -> patterns accepted so far
+	if a := cfg.Admin; a != nil && strings.HasPrefix(a.Route, "/") {
+		if err := muxError(a.Patterns()...); err != nil {
+			errs = append(errs, FieldError{Index: -1, Field: "admin.route", Message: err.Error()})
+		} else {
+			// the admin endpoints are served with the routes of no host
+			accepted[""] = append(accepted[""], owner{-1, "", a.Patterns()})
+		}
+	}
+	for i, svc := range cfg.Services {
+		if !strings.HasPrefix(svc.Route, "/") {
+			continue
+		}
+		svc.Route = normalizeRoute(svc.Route)
+		host := strings.ToLower(svc.Host)
+		patterns := svc.Patterns()
+		if err := muxError(patterns...); err != nil {
+			errs = append(errs, fieldError(i, svc, "route", "%v", err))
+			continue
+		}
+		conflict := false
+		for _, o := range accepted[host] {
+			// services sharing a route share its patterns; anyone else
+			// registering one of them would be silently shadowed
+			if muxError(append(append([]string{}, o.patterns...), patterns...)...) == nil &&
+				(o.route == svc.Route || !overlap(o.patterns, patterns)) {
+				continue
+			}
+			other := "the admin endpoints"
+			if o.index >= 0 {
+				other = fmt.Sprintf("the routes of services[%d] (%s)", o.index, cfg.Services[o.index].Route)
+			}
+			errs = append(errs, fieldError(i, svc, "route", "conflicts with %s", other))
+			conflict = true
+			break
+		}
+		if !conflict {
+			accepted[host] = append(accepted[host], owner{i, svc.Route, patterns})
+		}
+	}
+	return errs
+}
+
+// overlap reports whether a and b have a pattern in common.
+func overlap(a, b []string) bool {
+	for _, p := range a {
+		for _, q := range b {
+			if p == q {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// muxError returns the error ServeMux panics with when patterns are
+// registered on it, or nil when it accepts them all. Repeated patterns are
+// registered once, as the router does for services sharing a route.
+func muxError(patterns ...string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	mux := http.NewServeMux()
+	seen := make(map[string]bool, len(patterns))
+	for _, p := range patterns {
+		if !seen[p] {
+			seen[p] = true
+			mux.Handle(p, http.NotFoundHandler())
+		}
+	}
+	return nil
+}
+
+// perClientBackend names the setting that makes the backend serving a request
+// depend on the client rather than on the URL, or returns "". The cache and
+// the coalescer key on the URL alone and sit in front of the backend choice.
+func perClientBackend(svc ServiceConfig) string {
+	switch {
+	case svc.Split != nil:
+		return "split"
+	case svc.Sticky != nil:
+		return "sticky"
+	case svc.LB != nil && svc.LB.Strategy == StrategyHash && svc.LB.HashKey != "path":
+		return "a hash strategy not keyed on the path"
+	}
+	return ""
+}
+
+// handlerIsWebSocket reports whether the service proxies WebSocket targets.
+func handlerIsWebSocket(svc ServiceConfig) bool {
+	ups := svc.Upstreams()
+	return len(ups) > 0 && IsWebSocketURL(ups[0].URL)
+}
+
+// validHost accepts plain host names and *.domain wildcards, without ports.
+func validHost(host string) bool {
+	host = strings.TrimPrefix(host, "*.")
+	if host == "" || len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// validateTargets checks that every upstream has a valid URL and sane
+// settings, and that WebSocket and HTTP targets are not mixed in one service.
+// WebSocket services relay to one backend without a pool, so they take a
+// single target.
+func validateTargets(i int, svc ServiceConfig) []FieldError {
+	errs := validateUpstreams(i, svc, "", svc.Target, svc.Targets)
+	if !handlerIsWebSocket(svc) {
+		return errs
+	}
+	if ups := svc.Upstreams(); len(ups) > 1 {
+		errs = append(errs, fieldError(i, svc, "target", "WebSocket services are not load balanced and take a single target"))
+	} else if ups[0].MaxConns > 0 {
+		errs = append(errs, fieldError(i, svc, "targets[0].maxConns", "only applies to HTTP proxied services"))
+	}
+	return errs
+}
+
+// validateUpstreams checks a target shorthand or targets list; prefix
+// locates it within the service (e.g. "split.groups[0].").
+func validateUpstreams(i int, svc ServiceConfig, prefix, target string, targets []Upstream) []FieldError {
+	var errs []FieldError
+	schemes := make(map[string]bool)
+	names := make(map[string]bool)
+	field := prefix + "target"
+	primaries := 0
+
+	for j, up := range upstreams(target, targets) {
+		if len(targets) > 0 {
+			field = fmt.Sprintf("%stargets[%d]", prefix, j)
+		}
+		u, err := url.ParseRequestURI(up.URL)
+		if err != nil {
+			errs = append(errs, fieldError(i, svc, field, "invalid target URL %q: %v", up.URL, err))
+			continue
+		}
+		switch u.Scheme {
+		case "http", "https":
+			schemes["http"] = true
+		case "ws", "wss":
+			schemes["ws"] = true
+		default:
+			errs = append(errs, fieldError(i, svc, field, "unsupported scheme %q in %q", u.Scheme, up.URL))
+		}
+
+		if names[up.Name] {
+			errs = append(errs, fieldError(i, svc, field+".name", "duplicate upstream name %q", up.Name))
+		}
+		names[up.Name] = true
+		if *up.Weight < 1 {
+			errs = append(errs, fieldError(i, svc, field+".weight", "must be at least 1"))
+		}
+		if up.MaxConns < 0 {
+			errs = append(errs, fieldError(i, svc, field+".maxConns", "must not be negative"))
+		}
+		if !up.Backup {
+			primaries++
+		}
+	}
+	if schemes["http"] && schemes["ws"] {
+		errs = append(errs, fieldError(i, svc, prefix+"target", "cannot mix WebSocket and HTTP targets"))
+	}
+	if len(targets) > 0 && primaries == 0 {
+		errs = append(errs, fieldError(i, svc, prefix+"targets", "at least one target must not be a backup"))
+	}
+	return errs
+}
+
+// validateRewrite checks the path rewriting settings of a proxied service.
+func validateRewrite(i int, svc ServiceConfig) []FieldError {
+	var errs []FieldError
+	if svc.TemplateDir != "" && (svc.StripPrefix != "" || svc.AddPrefix != "" || len(svc.Rewrite) > 0) {
+		errs = append(errs, fieldError(i, svc, "stripPrefix", "path rewriting only applies to proxied services"))
+	}
+	if svc.StripPrefix != "" && !strings.HasPrefix(svc.StripPrefix, "/") {
+		errs = append(errs, fieldError(i, svc, "stripPrefix", "must start with \"/\""))
+	}
+	if svc.AddPrefix != "" && !strings.HasPrefix(svc.AddPrefix, "/") {
+		errs = append(errs, fieldError(i, svc, "addPrefix", "must start with \"/\""))
+	}
+	for j, rule := range svc.Rewrite {
+		if _, err := regexp.Compile(rule.Regex); err != nil || rule.Regex == "" {
+			errs = append(errs, fieldError(i, svc, fmt.Sprintf("rewrite[%d].regex", j), "invalid regex %q: %v", rule.Regex, err))
+		}
+	}
+	return errs
+}
+
+// validateHealthCheck checks the active health check settings.
+func validateHealthCheck(i int, svc ServiceConfig) []FieldError {
+	hc := svc.HealthCheck
+	if hc == nil {
+		return nil
+	}
+	var errs []FieldError
+	if svc.TemplateDir != "" || handlerIsWebSocket(svc) {
+		errs = append(errs, fieldError(i, svc, "healthCheck", "only applies to HTTP proxied services"))
+	}
+	if hc.Path != "" && !strings.HasPrefix(hc.Path, "/") {
+		errs = append(errs, fieldError(i, svc, "healthCheck.path", "must start with \"/\""))
+	}
+	if hc.Interval < 0 || hc.Timeout < 0 {
+		errs = append(errs, fieldError(i, svc, "healthCheck", "interval and timeout must not be negative"))
+	}
+	if hc.ExpectedStatus != 0 && (hc.ExpectedStatus < 100 || hc.ExpectedStatus > 599) {
+		errs = append(errs, fieldError(i, svc, "healthCheck.expectedStatus", "%d is not an HTTP status", hc.ExpectedStatus))
+	}
+	if hc.HealthyThreshold < 0 || hc.UnhealthyThreshold < 0 {
+		errs = append(errs, fieldError(i, svc, "healthCheck", "thresholds must not be negative"))
+	}
+	return errs
+}
+
+// validateOutlier checks the passive outlier detection settings.
+func validateOutlier(i int, svc ServiceConfig) []FieldError {
+	od := svc.Outlier
+	if od == nil {
+		return nil
+	}
+	var errs []FieldError
+	if svc.TemplateDir != "" || handlerIsWebSocket(svc) {
+		errs = append(errs, fieldError(i, svc, "outlierDetection", "only applies to HTTP proxied services"))
+	}
+	if od.ErrorRate < 0 || od.ErrorRate > 1 {
+		errs = append(errs, fieldError(i, svc, "outlierDetection.errorRate", "must be between 0 and 1"))
+	}
+	if od.MaxEjectionPercent < 0 || od.MaxEjectionPercent > 100 {
+		errs = append(errs, fieldError(i, svc, "outlierDetection.maxEjectionPercent", "must be between 0 and 100"))
+	}
+	if od.BaseEjection > 0 && od.MaxEjection > 0 && od.MaxEjection < od.BaseEjection {
+		errs = append(errs, fieldError(i, svc, "outlierDetection.maxEjection", "must not be shorter than baseEjection"))
+	}
+	if od.ConsecutiveErrors < 0 || od.MinRequests < 0 || od.Window < 0 {
+		errs = append(errs, fieldError(i, svc, "outlierDetection", "values must not be negative"))
+	}
+	return errs
+}
+
+// validateLB checks the load-balancing strategy and its hash key.
+func validateLB(i int, svc ServiceConfig) []FieldError {
+	lb := svc.LB
+	if lb == nil {
+		return nil
+	}
+	var errs []FieldError
+	if svc.TemplateDir != "" || handlerIsWebSocket(svc) {
+		errs = append(errs, fieldError(i, svc, "lb", "only applies to HTTP proxied services"))
+	}
+	switch lb.Strategy {
+	case StrategyRandom, StrategyRoundRobin, StrategyWeightedRoundRobin, StrategyLeastRequests, StrategyP2C:
+		if lb.HashKey != "" {
+			errs = append(errs, fieldError(i, svc, "lb.hashKey", "only applies to the %s strategy", StrategyHash))
+		}
+	case StrategyHash:
+		if err := ValidateHashKey(lb.HashKey); err != nil {
+			errs = append(errs, fieldError(i, svc, "lb.hashKey", "%v", err))
+		}
+	default:
+		errs = append(errs, fieldError(i, svc, "lb.strategy", "unknown strategy %q", lb.Strategy))
+	}
+	return errs
+}
+
+// validateRetry checks the retry policy of a service.
+func validateRetry(i int, svc ServiceConfig) []FieldError {
+	rt := svc.Retry
+	if rt == nil {
+		return nil
+	}
+	var errs []FieldError
+	if svc.TemplateDir != "" || handlerIsWebSocket(svc) {
+		errs = append(errs, fieldError(i, svc, "retry", "only applies to HTTP proxied services"))
+	}
+	if rt.Attempts < 0 || rt.Backoff < 0 || rt.MaxBackoff < 0 || rt.MaxBodySize < 0 {
+		errs = append(errs, fieldError(i, svc, "retry", "values must not be negative"))
+	}
+	if rt.Backoff > 0 && rt.MaxBackoff > 0 && rt.MaxBackoff < rt.Backoff {
+		errs = append(errs, fieldError(i, svc, "retry.maxBackoff", "must not be shorter than backoff"))
+	}
+	for _, code := range rt.RetryOn {
+		if code < 400 || code > 599 {
+			errs = append(errs, fieldError(i, svc, "retry.retryOn", "%d is not a 4xx or 5xx status", code))
+		}
+	}
+	return errs
+}
+
+// ValidateHashKey checks a key source of the form ip, path, header:<name>
+// or cookie:<name>. An empty key means ip.
+func ValidateHashKey(key string) error {
+	kind, name, hasName := strings.Cut(key, ":")
+	switch kind {
+	case "", "ip", "path":
+		if hasName {
+			return fmt.Errorf("%q takes no name", kind)
+		}
+	case "header", "cookie":
+		if name == "" {
+			return fmt.Errorf("%q requires a name, e.g. %s:X-User", kind, kind)
+		}
+	default:
+		return fmt.Errorf("unknown key source %q", key)
+	}
+	return nil
+}
+
+// validateMiddleware checks the effective middleware settings of a service.
+func validateMiddleware(i int, svc ServiceConfig, mw MiddlewareConfig) []FieldError {
+	var errs []FieldError
+	if rl := mw.RateLimit; rl != nil {
+		if rl.RPS < 0 {
+			errs = append(errs, fieldError(i, svc, "middleware.rateLimit.rps", "must not be negative"))
+		}
+		if rl.RPS > 0 && rl.Burst < 1 {
+			errs = append(errs, fieldError(i, svc, "middleware.rateLimit.burst", "must be at least 1"))
+		}
+	}
+	if mw.ConnLimit != nil && *mw.ConnLimit < 0 {
+		errs = append(errs, fieldError(i, svc, "middleware.connLimit", "must not be negative"))
+	}
+	if mw.Queue != nil && *mw.Queue < 0 {
+		errs = append(errs, fieldError(i, svc, "middleware.queue", "must not be negative"))
+	}
+	return errs
+}
+
+// CheckEnvironment verifies what LoadConfig cannot see from the YAML alone:
+// that template directories exist, that log files can be written and that
+// TLS certificates load.
+func CheckEnvironment(cfg *Config) []FieldError {
+	var errs []FieldError
+	for i, svc := range cfg.Services {
+		if svc.TemplateDir != "" {
+			info, err := os.Stat(svc.TemplateDir)
+			switch {
+			case err != nil:
+				errs = append(errs, fieldError(i, svc, "templateDir", "%v", err))
+			case !info.IsDir():
+				errs = append(errs, fieldError(i, svc, "templateDir", "%s is not a directory", svc.TemplateDir))
+			}
+		}
+		if svc.Log == "" {
+			errs = append(errs, fieldError(i, svc, "log", "log file is required"))
+		} else if err := checkWritableDir(filepath.Dir(svc.Log)); err != nil {
+			errs = append(errs, fieldError(i, svc, "log", "%v", err))
+		}
+		if svc.TLS != nil {
+			if err := svc.TLS.load(&tls.Config{}); err != nil {
+				errs = append(errs, fieldError(i, svc, "tls", "%v", err))
+			}
+		}
+		if svc.Mirror != nil && svc.Log != "" {
+			if err := checkWritableDir(filepath.Dir(svc.MirrorLog())); err != nil {
+				errs = append(errs, fieldError(i, svc, "mirror.log", "%v", err))
+			}
+		}
+	}
+	return errs
+}
+
+// checkWritableDir reports whether files can be created in dir. A missing
+// directory is fine as long as its closest existing parent is writable,
+// since the router creates log directories on startup.
+func checkWritableDir(dir string) error {
+	for {
+		info, err := os.Stat(dir)
+		if os.IsNotExist(err) {
+			parent := filepath.Dir(dir)
+			if parent == dir {
+				return err
+			}
+			dir = parent
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+		f, err := os.CreateTemp(dir, ".gateway-check-*")
+		if err != nil {
+			return fmt.Errorf("%s is not writable: %v", dir, err)
+		}
+		f.Close()
+		os.Remove(f.Name())
+		return nil
+	}
+}
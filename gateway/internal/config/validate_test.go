@@ -0,0 +1,164 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// loadYAML writes yml to a temporary config.yml and loads it.
+func loadYAML(t *testing.T, yml string) (*Config, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(path, []byte(yml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return LoadConfig(path)
+}
+
+func TestTrailingSlashRoutesAreDuplicates(t *testing.T) {
+	_, err := loadYAML(t, `services:
+  - route: /api
+    target: http://a:8000
+  - route: /api/
+    target: http://b:8000
+`)
+	if err == nil || !strings.Contains(err.Error(), "duplicate route") {
+		t.Fatalf("err = %v, want a duplicate route error", err)
+	}
+}
+
+func TestRoutesAreNormalized(t *testing.T) {
+	cfg, err := loadYAML(t, `services:
+  - route: /api//
+    target: http://a:8000
+  - route: /
+    target: http://b:8000
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Services[0].Route; got != "/api" {
+		t.Errorf("route = %q, want /api", got)
+	}
+	if got := cfg.Services[1].Route; got != "/" {
+		t.Errorf("route = %q, want /", got)
+	}
+}
+
+func TestInvalidRoutePatterns(t *testing.T) {
+	for _, tc := range []struct {
+		routes []string
+		want   string
+	}{
+		{[]string{"/a b"}, "invalid method"},
+		{[]string{"/{id"}, "bad wildcard segment"},
+		{[]string{"/files/{path...}"}, "not at end"},
+		{[]string{"/{id}", "/{name}"}, "conflicts with the routes of services[0]"},
+	} {
+		yml := "services:\n"
+		for _, r := range tc.routes {
+			yml += "  - route: \"" + r + "\"\n    target: http://a:8000\n"
+		}
+		_, err := loadYAML(t, yml)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: err = %v, want %q", tc.routes, err, tc.want)
+		}
+	}
+}
+
+func TestWebSocketPoolSettingsRejected(t *testing.T) {
+	for _, tc := range []struct{ extra, want string }{
+		{"    healthCheck: {path: /health}\n", "healthCheck: only applies to HTTP proxied services"},
+		{"    outlierDetection: {consecutiveErrors: 3}\n", "outlierDetection: only applies to HTTP proxied services"},
+		{"    lb: {strategy: roundRobin}\n", "lb: only applies to HTTP proxied services"},
+		{"    targets:\n      - {url: \"ws://a:8000\", maxConns: 5}\n", "maxConns: only applies to HTTP proxied services"},
+		{"    target: ws://a:8000, ws://b:8000\n", "take a single target"},
+	} {
+		yml := "services:\n  - route: /ws\n"
+		if !strings.Contains(tc.extra, "target") {
+			yml += "    target: ws://a:8000\n"
+		}
+		_, err := loadYAML(t, yml+tc.extra)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%q: err = %v, want %q", tc.extra, err, tc.want)
+		}
+	}
+}
+
+func TestLoginPagesConflictWithRoutes(t *testing.T) {
+	// /x/ would clash with /{id}/login/ once the router registers both
+	_, err := loadYAML(t, `services:
+  - route: "/{id}"
+    target: http://a:8000
+    login: true
+    session_duration: 60
+  - route: /x
+    target: http://b:8000
+`)
+	if err == nil || !strings.Contains(err.Error(), "conflicts with the routes of services[0]") {
+		t.Fatalf("err = %v, want a route conflict", err)
+	}
+}
+
+func TestAdminRouteConflict(t *testing.T) {
+	_, err := loadYAML(t, `admin: {route: /_gw}
+services:
+  - route: /_gw/metrics
+    target: http://a:8000
+`)
+	if err == nil || !strings.Contains(err.Error(), "conflicts with the admin endpoints") {
+		t.Fatalf("err = %v, want a conflict with the admin endpoints", err)
+	}
+}
+
+func TestCacheAndCoalesceNeedURLKeyedBackends(t *testing.T) {
+	for _, tc := range []struct {
+		name, extra string
+		ok          bool
+	}{
+		{"split", "    split:\n      groups:\n        - {name: stable, weight: 90, target: \"http://a:8000\"}\n        - {name: canary, weight: 10, target: \"http://b:8000\"}\n", false},
+		{"sticky", "    target: http://a:8000, http://b:8000\n    sticky: {}\n", false},
+		{"hash on ip", "    target: http://a:8000, http://b:8000\n    lb: {strategy: hash, hashKey: ip}\n", false},
+		{"hash on path", "    target: http://a:8000, http://b:8000\n    lb: {strategy: hash, hashKey: path}\n", true},
+		{"round robin", "    target: http://a:8000, http://b:8000\n", true},
+	} {
+		for _, field := range []string{"cache", "coalesce"} {
+			_, err := loadYAML(t, "services:\n  - route: /api\n    "+field+": {}\n"+tc.extra)
+			switch {
+			case tc.ok && err != nil:
+				t.Errorf("%s with %s: %v", field, tc.name, err)
+			case !tc.ok && (err == nil || !strings.Contains(err.Error(), field+": cannot be combined")):
+				t.Errorf("%s with %s: err = %v, want it rejected", field, tc.name, err)
+			}
+		}
+	}
+}
+
+func TestUpstreamWeights(t *testing.T) {
+	cfg, err := loadYAML(t, `services:
+  - route: /api
+    targets:
+      - {url: "http://a:8000"}
+      - {url: "http://b:8000", weight: 3}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ups := cfg.Services[0].Upstreams()
+	if *ups[0].Weight != 1 || *ups[1].Weight != 3 {
+		t.Errorf("weights = %d, %d, want 1 (default) and 3", *ups[0].Weight, *ups[1].Weight)
+	}
+
+	for _, w := range []string{"0", "-1"} {
+		_, err := loadYAML(t, `services:
+  - route: /api
+    targets:
+      - {url: "http://a:8000", weight: `+w+`}
+`)
+		if err == nil || !strings.Contains(err.Error(), "targets[0].weight: must be at least 1") {
+			t.Errorf("weight %s: err = %v, want it rejected", w, err)
+		}
+	}
+}
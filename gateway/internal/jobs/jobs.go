@@ -6,9 +6,9 @@ import (
 	"github.com/robfig/cron/v3"
 )
 
-// InitJobScheduler initializes the cron scheduler and schedules jobs from jobs.yml
-func InitJobScheduler() error {
-	jobConfigs, err := LoadJobConfig("jobs.yml")
+// InitJobScheduler initializes the cron scheduler and schedules jobs from the given jobs file
+func InitJobScheduler(path string) error {
+	jobConfigs, err := LoadJobConfig(path)
 	if err != nil {
 		return err
 	}
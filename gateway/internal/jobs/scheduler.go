@@ -3,7 +3,7 @@ package jobs
 import (
 	"os"
 
-	"gopkg.in/yaml.v3"
+	"github.com/RafaelZelak/gateway/internal/config"
 )
 
 // JobConfig represents a single job entry in jobs.yml
@@ -18,13 +18,13 @@ type JobFile struct {
 	Jobs []JobConfig `yaml:"jobs"`
 }
 
-// LoadJobConfig reads and unmarshals the jobs YAML file
+// LoadJobConfig reads, expands and unmarshals the jobs YAML file
 func LoadJobConfig(path string) ([]JobConfig, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 	var cfg JobFile
-	err = yaml.Unmarshal(data, &cfg)
+	err = config.Unmarshal(data, &cfg)
 	return cfg.Jobs, err
 }
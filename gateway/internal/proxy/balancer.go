@@ -0,0 +1,292 @@
+package proxy
+
+import (
+	"math/rand"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/RafaelZelak/gateway/internal/config"
+)
+
+// Backend is one upstream of a load-balanced service.
+type Backend struct {
+	Name     string
+	URL      *url.URL
+	Weight   int
+	MaxConns int
+	Backup   bool
+
+	proxy        *httputil.ReverseProxy
+	active       atomic.Int64
+	healthy      atomic.Bool
+	ejectedUntil atomic.Int64 // unix nanoseconds
+	outlier      outlierState
+	breaker      *breaker
+}
+
+// acquire reserves a connection slot, failing when MaxConns is reached.
+func (b *Backend) acquire() bool {
+	for {
+		n := b.active.Load()
+		if b.MaxConns > 0 && n >= int64(b.MaxConns) {
+			return false
+		}
+		if b.active.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+func (b *Backend) release() { b.active.Add(-1) }
+
+// available reports whether the backend is healthy, not ejected, has no
+// open circuit and can take another request.
+func (b *Backend) available() bool {
+	now := time.Now()
+	if !b.healthy.Load() || b.ejected(now) || !b.breaker.admits(now) {
+		return false
+	}
+	return b.MaxConns == 0 || b.active.Load() < int64(b.MaxConns)
+}
+
+// LoadBalancer spreads requests over weighted backends. Backup backends only
+// receive traffic when no primary backend is available.
+type LoadBalancer struct {
+	backends []*Backend
+	balancer Balancer
+	stop     chan struct{}
+
+	outlier *config.OutlierDetection
+	ejectMu sync.Mutex
+	sticky  *stickiness
+	retry   *retryPolicy
+	checked bool // health checks run
+}
+
+// BuildLoadBalancer creates a proxy for the given upstreams, as resolved by
+// ServiceConfig.Upstreams, that picks backends with the configured strategy
+// (weighted random when lb is nil).
+func BuildLoadBalancer(upstreams []config.Upstream, lbConfig *config.LoadBalancing, transport http.RoundTripper) (*LoadBalancer, error) {
+	lb := &LoadBalancer{stop: make(chan struct{})}
+	for _, up := range upstreams {
+		p, err := BuildReverseProxy(up.URL, transport)
+		if err != nil {
+			return nil, err
+		}
+		u, _ := url.Parse(up.URL)
+		b := &Backend{
+			Name:     up.Name,
+			URL:      u,
+			Weight:   *up.Weight,
+			MaxConns: up.MaxConns,
+			Backup:   up.Backup,
+			proxy:    p,
+		}
+		b.healthy.Store(true)
+		lb.backends = append(lb.backends, b)
+	}
+	balancer, err := NewBalancer(lbConfig, lb.backends)
+	if err != nil {
+		return nil, err
+	}
+	lb.balancer = balancer
+	return lb, nil
+}
+
+// Close stops the background work of the balancer (health checks).
+func (lb *LoadBalancer) Close() error {
+	select {
+	case <-lb.stop:
+	default:
+		close(lb.stop)
+	}
+	return nil
+}
+
+// AdoptState carries over what prev, the balancer of the same pool in the
+// previous config, learned about the backends both share (matched by URL):
+// health check results, outlier ejections and circuit states. checked
+// tells whether lb will run health checks; without them every backend
+// starts healthy. Call it after enabling the features and before any
+// traffic or health check.
+func (lb *LoadBalancer) AdoptState(prev *LoadBalancer, checked bool) {
+	old := make(map[string]*Backend, len(prev.backends))
+	for _, b := range prev.backends {
+		old[b.URL.String()] = b
+	}
+	for _, b := range lb.backends {
+		p := old[b.URL.String()]
+		if p == nil {
+			continue
+		}
+		if checked && prev.checked {
+			b.healthy.Store(p.healthy.Load())
+		}
+		if lb.outlier != nil && prev.outlier != nil {
+			b.ejectedUntil.Store(p.ejectedUntil.Load())
+			p.outlier.mu.Lock()
+			b.outlier.ejections = p.outlier.ejections
+			p.outlier.mu.Unlock()
+		}
+		b.breaker.adopt(p.breaker)
+	}
+}
+
+// BackendStatus is the externally visible state of a backend.
+type BackendStatus struct {
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	Healthy  bool   `json:"healthy"`
+	Ejected  bool   `json:"ejected"`
+	Active   int64  `json:"active"`
+	Weight   int    `json:"weight"`
+	MaxConns int    `json:"maxConns,omitempty"`
+	Backup   bool   `json:"backup,omitempty"`
+	Circuit  string `json:"circuit,omitempty"`
+	Opens    int64  `json:"circuitOpens,omitempty"`
+}
+
+// Status reports the current state of every backend.
+func (lb *LoadBalancer) Status() []BackendStatus {
+	now := time.Now()
+	out := make([]BackendStatus, len(lb.backends))
+	for i, b := range lb.backends {
+		circuit, opens := b.breaker.snapshot()
+		out[i] = BackendStatus{
+			Name:     b.Name,
+			URL:      b.URL.String(),
+			Healthy:  b.healthy.Load(),
+			Ejected:  b.ejected(now),
+			Active:   b.active.Load(),
+			Weight:   b.Weight,
+			MaxConns: b.MaxConns,
+			Backup:   b.Backup,
+			Circuit:  circuit,
+			Opens:    opens,
+		}
+	}
+	return out
+}
+
+// candidates returns the available backends of the requested tier.
+func (lb *LoadBalancer) candidates(backup bool) []*Backend {
+	var out []*Backend
+	for _, b := range lb.backends {
+		if b.Backup == backup && b.available() {
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+// next picks a backend and reserves a slot on it, preferring primaries.
+// Backends in skip are not considered.
+func (lb *LoadBalancer) next(r *http.Request, skip ...*Backend) *Backend {
+	for _, backup := range []bool{false, true} {
+		cands := lb.candidates(backup)
+		for _, b := range skip {
+			cands = without(cands, b)
+		}
+		for len(cands) > 0 {
+			b := lb.balancer.Pick(r, cands)
+			if b.acquire() {
+				return b
+			}
+			// lost the race for the last slot, try the others
+			cands = without(cands, b)
+		}
+	}
+	return nil
+}
+
+// without returns cands minus b, preserving order.
+func without(cands []*Backend, b *Backend) []*Backend {
+	out := make([]*Backend, 0, len(cands))
+	for _, c := range cands {
+		if c != b {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// pickWeighted returns the index of a backend chosen with probability
+// proportional to its weight.
+func pickWeighted(cands []*Backend) int {
+	total := 0
+	for _, b := range cands {
+		total += b.Weight
+	}
+	if total <= 0 {
+		return rand.Intn(len(cands))
+	}
+	n := rand.Intn(total)
+	for i, b := range cands {
+		if n < b.Weight {
+			return i
+		}
+		n -= b.Weight
+	}
+	return len(cands) - 1
+}
+
+// ServeHTTP proxies the request to the selected backend.
+func (lb *LoadBalancer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if lb.retry != nil {
+		lb.serveWithRetries(w, r)
+		return
+	}
+	b := lb.choose(w, r, nil)
+	if b == nil {
+		lb.unavailable(w, r)
+		return
+	}
+	lb.forward(b, w, r)
+}
+
+// choose reserves a slot on the backend for r: the pinned one on the first
+// try, otherwise a new pick that prefers backends not tried yet. The client
+// is (re-)pinned when it moves to another backend.
+func (lb *LoadBalancer) choose(w http.ResponseWriter, r *http.Request, tried []*Backend) *Backend {
+	if lb.sticky != nil && len(tried) == 0 {
+		if b := lb.pinnedBackend(r); b != nil {
+			return b
+		}
+	}
+	b := lb.next(r, tried...)
+	if b == nil && len(tried) > 0 {
+		b = lb.next(r)
+	}
+	// a pin set on a cache refresh would make the response uncacheable
+	if b != nil && lb.sticky != nil && !isRefresh(r) {
+		lb.sticky.pin(w, b.Name)
+	}
+	return b
+}
+
+// forward proxies r to b, whose slot it releases, and reports the outcome
+// to outlier detection and the circuit breaker. Nothing written means the
+// failure was swallowed for a retry.
+func (lb *LoadBalancer) forward(b *Backend, w http.ResponseWriter, r *http.Request) {
+	defer b.release()
+
+	gen, ok := b.breaker.allow(time.Now())
+	if !ok {
+		// the last probe slot was taken since the backend was picked
+		lb.unavailable(w, r)
+		return
+	}
+	rec := &statusRecorder{ResponseWriter: w}
+	b.proxy.ServeHTTP(rec, r)
+	if callerGaveUp(r) {
+		b.breaker.cancel(gen)
+		return
+	}
+	failed := rec.status == 0 || rec.status >= 500
+	lb.record(b, failed)
+	b.breaker.done(gen, failed)
+}
@@ -0,0 +1,208 @@
+package proxy
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/RafaelZelak/gateway/internal/config"
+	"github.com/RafaelZelak/gateway/pkg/httperr"
+)
+
+// Circuit breaker states.
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// breaker is the circuit breaker of one backend. A nil breaker lets
+// everything through.
+type breaker struct {
+	cfg  config.CircuitBreaker
+	name string
+
+	mu        sync.Mutex
+	state     string
+	gen       uint64 // bumped on every transition, so stale results are ignored
+	failures  int
+	successes int
+	probes    int // requests in flight while half-open
+	openedAt  time.Time
+	opens     int64
+}
+
+// EnableCircuitBreakers puts a circuit breaker in front of every backend.
+func (lb *LoadBalancer) EnableCircuitBreakers(cb config.CircuitBreaker) {
+	cb = cb.WithDefaults()
+	for _, b := range lb.backends {
+		b.breaker = &breaker{cfg: cb, name: b.Name, state: CircuitClosed}
+	}
+}
+
+// admits reports, without reserving anything, whether a request could be
+// let through now.
+func (cb *breaker) admits(now time.Time) bool {
+	if cb == nil {
+		return true
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	switch cb.state {
+	case CircuitOpen:
+		return now.Sub(cb.openedAt) >= cb.cfg.OpenTimeout
+	case CircuitHalfOpen:
+		return cb.probes < cb.cfg.HalfOpenRequests
+	}
+	return true
+}
+
+// allow lets a request through, moving an expired open circuit to
+// half-open. The returned generation is handed back to done.
+func (cb *breaker) allow(now time.Time) (uint64, bool) {
+	if cb == nil {
+		return 0, true
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.state == CircuitOpen {
+		if now.Sub(cb.openedAt) < cb.cfg.OpenTimeout {
+			return 0, false
+		}
+		cb.transition(CircuitHalfOpen, "open timeout elapsed")
+	}
+	if cb.state == CircuitHalfOpen {
+		if cb.probes >= cb.cfg.HalfOpenRequests {
+			return 0, false
+		}
+		cb.probes++
+	}
+	return cb.gen, true
+}
+
+// done records the outcome of a request admitted in generation gen.
+func (cb *breaker) done(gen uint64, failed bool) {
+	if cb == nil {
+		return
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if gen != cb.gen {
+		return
+	}
+	switch cb.state {
+	case CircuitClosed:
+		if !failed {
+			cb.failures = 0
+			return
+		}
+		cb.failures++
+		if cb.failures >= cb.cfg.FailureThreshold {
+			cb.transition(CircuitOpen, strconv.Itoa(cb.failures)+" consecutive failures")
+		}
+	case CircuitHalfOpen:
+		cb.probes--
+		if failed {
+			cb.transition(CircuitOpen, "probe failed")
+			return
+		}
+		cb.successes++
+		if cb.successes >= cb.cfg.SuccessThreshold {
+			cb.transition(CircuitClosed, strconv.Itoa(cb.successes)+" successful probes")
+		}
+	}
+}
+
+// cancel gives back the probe slot of a request whose outcome says nothing
+// about the backend (the client went away).
+func (cb *breaker) cancel(gen uint64) {
+	if cb == nil {
+		return
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if gen == cb.gen && cb.state == CircuitHalfOpen {
+		cb.probes--
+	}
+}
+
+// transition moves to state to and logs why. Callers hold mu.
+func (cb *breaker) transition(to, reason string) {
+	log.Printf("[BREAKER] backend %s circuit %s -> %s: %s", cb.name, cb.state, to, reason)
+	cb.state = to
+	cb.gen++
+	cb.failures, cb.successes, cb.probes = 0, 0, 0
+	if to == CircuitOpen {
+		cb.openedAt = time.Now()
+		cb.opens++
+	}
+}
+
+// adopt takes over the state of prev, the breaker of the same backend in
+// the previous config. Probes still in flight stay with prev.
+func (cb *breaker) adopt(prev *breaker) {
+	if cb == nil || prev == nil {
+		return
+	}
+	prev.mu.Lock()
+	state, failures, openedAt, opens := prev.state, prev.failures, prev.openedAt, prev.opens
+	prev.mu.Unlock()
+	if state == CircuitHalfOpen {
+		// the probes belong to prev; reopen so new ones can be sent
+		state, openedAt = CircuitOpen, time.Time{}
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.state, cb.failures, cb.openedAt, cb.opens = state, failures, openedAt, opens
+}
+
+// snapshot returns the state and the number of times the circuit opened.
+func (cb *breaker) snapshot() (string, int64) {
+	if cb == nil {
+		return "", 0
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state, cb.opens
+}
+
+// openFor returns how long the circuit stays open, or false if it is not.
+func (cb *breaker) openFor(now time.Time) (time.Duration, bool) {
+	if cb == nil {
+		return 0, false
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.state == CircuitHalfOpen {
+		// probes are in flight; their result is known soon
+		return time.Second, true
+	}
+	if cb.state != CircuitOpen {
+		return 0, false
+	}
+	wait := cb.cfg.OpenTimeout - now.Sub(cb.openedAt)
+	return wait, wait > 0
+}
+
+// unavailable answers a request that no backend can take. When open
+// circuits are the reason, the client is told when to come back.
+func (lb *LoadBalancer) unavailable(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	var wait time.Duration
+	open := false
+	for _, b := range lb.backends {
+		if d, ok := b.breaker.openFor(now); ok && (!open || d < wait) {
+			wait, open = d, true
+		}
+	}
+	if !open {
+		httperr.Write(w, r, http.StatusServiceUnavailable, httperr.CodeNoUpstream, "no healthy upstream available")
+		return
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Max(1, math.Ceil(wait.Seconds())))))
+	httperr.Write(w, r, http.StatusServiceUnavailable, httperr.CodeCircuitOpen, "circuit breaker open")
+}
@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/RafaelZelak/gateway/internal/config"
+)
+
+func newTestBreaker(cfg config.CircuitBreaker) *breaker {
+	return &breaker{cfg: cfg.WithDefaults(), name: "a", state: CircuitClosed}
+}
+
+// fail runs n failed requests through cb.
+func fail(t *testing.T, cb *breaker, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		gen, ok := cb.allow(time.Now())
+		if !ok {
+			t.Fatalf("request %d refused", i+1)
+		}
+		cb.done(gen, true)
+	}
+}
+
+func TestBreakerOpensAndRecovers(t *testing.T) {
+	cb := newTestBreaker(config.CircuitBreaker{FailureThreshold: 3, OpenTimeout: time.Minute, HalfOpenRequests: 1, SuccessThreshold: 2})
+
+	fail(t, cb, 2)
+	gen, _ := cb.allow(time.Now())
+	cb.done(gen, false) // a success resets the count
+	fail(t, cb, 2)
+	if state, _ := cb.snapshot(); state != CircuitClosed {
+		t.Fatalf("state = %s after non-consecutive failures, want closed", state)
+	}
+	fail(t, cb, 1)
+	if state, opens := cb.snapshot(); state != CircuitOpen || opens != 1 {
+		t.Fatalf("state = %s, opens = %d, want open once", state, opens)
+	}
+
+	now := time.Now()
+	if _, ok := cb.allow(now); ok {
+		t.Fatal("open circuit let a request through")
+	}
+	if d, ok := cb.openFor(now); !ok || d <= 0 || d > time.Minute {
+		t.Errorf("openFor = %v, %v, want up to a minute", d, ok)
+	}
+
+	// after the timeout one probe at a time goes through
+	later := now.Add(time.Minute)
+	probe, ok := cb.allow(later)
+	if !ok {
+		t.Fatal("probe refused after the open timeout")
+	}
+	if _, ok := cb.allow(later); ok {
+		t.Fatal("second concurrent probe allowed, want halfOpenRequests = 1")
+	}
+	cb.done(probe, false)
+	if state, _ := cb.snapshot(); state != CircuitHalfOpen {
+		t.Fatalf("state = %s after one of two successful probes, want half-open", state)
+	}
+	probe, _ = cb.allow(later)
+	cb.done(probe, false)
+	if state, _ := cb.snapshot(); state != CircuitClosed {
+		t.Fatalf("state = %s after two successful probes, want closed", state)
+	}
+}
+
+func TestBreakerFailedProbeReopens(t *testing.T) {
+	cb := newTestBreaker(config.CircuitBreaker{FailureThreshold: 1, OpenTimeout: time.Minute})
+	fail(t, cb, 1)
+	probe, ok := cb.allow(time.Now().Add(time.Minute))
+	if !ok {
+		t.Fatal("probe refused after the open timeout")
+	}
+	cb.done(probe, true)
+	if state, opens := cb.snapshot(); state != CircuitOpen || opens != 2 {
+		t.Errorf("state = %s, opens = %d after a failed probe, want open twice", state, opens)
+	}
+}
+
+func TestBreakerIgnoresStaleAndCanceledResults(t *testing.T) {
+	cb := newTestBreaker(config.CircuitBreaker{FailureThreshold: 1, OpenTimeout: time.Minute})
+	old, _ := cb.allow(time.Now())
+	fail(t, cb, 1)
+
+	// a request admitted while closed finishes after the circuit opened
+	cb.done(old, false)
+	if state, _ := cb.snapshot(); state != CircuitOpen {
+		t.Fatalf("state = %s after a stale success, want open", state)
+	}
+
+	later := time.Now().Add(time.Minute)
+	probe, _ := cb.allow(later)
+	cb.cancel(probe)
+	if _, ok := cb.allow(later); !ok {
+		t.Error("probe slot not given back by cancel")
+	}
+}
+
+func TestBreakerAdopt(t *testing.T) {
+	cfg := config.CircuitBreaker{FailureThreshold: 3, OpenTimeout: time.Minute}
+
+	closed := newTestBreaker(cfg)
+	fail(t, closed, 2)
+	next := newTestBreaker(cfg)
+	next.adopt(closed)
+	fail(t, next, 1)
+	if state, _ := next.snapshot(); state != CircuitOpen {
+		t.Errorf("state = %s, want the adopted failures to count", state)
+	}
+
+	open := newTestBreaker(cfg)
+	fail(t, open, 3)
+	next = newTestBreaker(cfg)
+	next.adopt(open)
+	if state, opens := next.snapshot(); state != CircuitOpen || opens != 1 {
+		t.Errorf("state = %s, opens = %d, want the open circuit kept", state, opens)
+	}
+	if _, ok := next.allow(time.Now()); ok {
+		t.Error("adopted open circuit let a request through before its timeout")
+	}
+
+	// half-open: the probes in flight stay with the old breaker, so the new
+	// one is open with its timeout already elapsed and probes at once
+	half := newTestBreaker(cfg)
+	fail(t, half, 3)
+	if _, ok := half.allow(time.Now().Add(time.Minute)); !ok {
+		t.Fatal("probe refused")
+	}
+	next = newTestBreaker(cfg)
+	next.adopt(half)
+	next.mu.Lock()
+	state, openedAt := next.state, next.openedAt
+	next.mu.Unlock()
+	if state != CircuitOpen || !openedAt.IsZero() {
+		t.Fatalf("adopted half-open: state = %s, openedAt = %v, want open with a zero openedAt", state, openedAt)
+	}
+	if !next.admits(time.Now()) {
+		t.Error("adopted half-open circuit does not admit a new probe")
+	}
+	if _, ok := next.allow(time.Now()); !ok {
+		t.Error("adopted half-open circuit refused a new probe")
+	}
+	if state, _ := next.snapshot(); state != CircuitHalfOpen {
+		t.Errorf("state = %s after the new probe, want half-open", state)
+	}
+}
+
+func TestUnavailableRetryAfter(t *testing.T) {
+	lb := &LoadBalancer{backends: []*Backend{{Name: "a"}, {Name: "b"}}}
+	rec := httptest.NewRecorder()
+	lb.unavailable(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "" {
+		t.Errorf("without breakers: %d, Retry-After %q, want 503 without it", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	for i, timeout := range []time.Duration{30 * time.Second, 10 * time.Second} {
+		b := lb.backends[i]
+		b.breaker = newTestBreaker(config.CircuitBreaker{FailureThreshold: 1, OpenTimeout: timeout})
+		fail(t, b.breaker, 1)
+	}
+	rec = httptest.NewRecorder()
+	lb.unavailable(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := rec.Header().Get("Retry-After"); got != "10" {
+		t.Errorf("Retry-After = %q, want the shortest remaining open time, 10", got)
+	}
+}
@@ -0,0 +1,526 @@
+package proxy
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/RafaelZelak/gateway/internal/config"
+)
+
+// cacheableStatus lists the responses a shared cache may store.
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusGone:                 true,
+}
+
+// Cache serves GET and HEAD requests of a service from memory, following
+// the HTTP caching headers of the upstream responses.
+type Cache struct {
+	cfg  config.Cache
+	next http.Handler
+
+	mu    sync.Mutex
+	lru   *list.List                 // of *cacheEntry, most recently used first
+	index map[string][]*list.Element // request key -> Vary variants
+	bytes int
+}
+
+// cacheEntry is a stored response. Entries are never modified once stored;
+// a revalidated response replaces its entry.
+type cacheEntry struct {
+	key        string
+	uri        string
+	vary       []string // request headers the response varies on
+	varyValues []string
+	status     int
+	header     http.Header
+	body       []byte
+	stored     time.Time
+	initialAge time.Duration
+	fresh      time.Duration // freshness lifetime
+	swr        time.Duration // stale-while-revalidate
+	sie        time.Duration // stale-if-error
+	public     bool          // Cache-Control: public, may go to requests with cookies
+
+	revalidating *atomic.Bool // shared by the entries replacing each other
+}
+
+// NewCache puts a response cache in front of next.
+func NewCache(cfg config.Cache, next http.Handler) *Cache {
+	return &Cache{
+		cfg:   cfg.WithDefaults(),
+		next:  next,
+		lru:   list.New(),
+		index: make(map[string][]*list.Element),
+	}
+}
+
+func (e *cacheEntry) age(now time.Time) time.Duration {
+	return e.initialAge + now.Sub(e.stored)
+}
+
+func (e *cacheEntry) size() int {
+	n := len(e.body) + len(e.uri)
+	for k, vs := range e.header {
+		for _, v := range vs {
+			n += len(k) + len(v)
+		}
+	}
+	return n
+}
+
+// matches reports whether the request selects this Vary variant.
+func (e *cacheEntry) matches(r *http.Request) bool {
+	for i, name := range e.vary {
+		if strings.Join(r.Header.Values(name), ",") != e.varyValues[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// cacheKey identifies a resource by host and request URI.
+func cacheKey(r *http.Request) string {
+	return r.Host + r.URL.RequestURI()
+}
+
+// ServeHTTP answers from the cache when it can and stores what the
+// upstream sends otherwise.
+func (c *Cache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := cacheKey(r)
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		c.next.ServeHTTP(w, r)
+		// a write through the gateway makes the stored copy suspect
+		if r.Method != http.MethodOptions && r.Method != http.MethodTrace {
+			c.invalidate(key)
+		}
+		return
+	}
+	reqCC := parseCacheControl(r.Header)
+	if has(reqCC, "no-store") || r.Header.Get("Authorization") != "" || r.Header.Get("Range") != "" {
+		w.Header().Set("X-Cache", "BYPASS")
+		c.next.ServeHTTP(w, r)
+		return
+	}
+
+	now := time.Now()
+	e := c.get(key, r)
+	if e != nil && !e.public && r.Header.Get("Cookie") != "" {
+		// the client may be logged in; only explicitly public copies apply
+		e = nil
+	}
+	if e == nil {
+		c.fetch(w, r, nil)
+		return
+	}
+	noCache := has(reqCC, "no-cache")
+	age := e.age(now)
+	switch {
+	case !noCache && age < e.fresh:
+		c.serve(w, r, e, now)
+	case !noCache && age < e.fresh+e.swr:
+		c.serve(w, r, e, now)
+		c.revalidate(r, e)
+	case r.Method == http.MethodHead:
+		c.fetch(w, r, nil)
+	default:
+		c.fetch(w, r, e)
+	}
+}
+
+// serve writes a stored response, or a 304 when the client already has it.
+func (c *Cache) serve(w http.ResponseWriter, r *http.Request, e *cacheEntry, now time.Time) {
+	h := w.Header()
+	for k, vs := range e.header {
+		h[k] = append([]string(nil), vs...)
+	}
+	h.Set("Age", strconv.Itoa(int(e.age(now).Seconds())))
+	h.Set("X-Cache", "HIT")
+	if etag := e.header.Get("ETag"); etag != "" && etagMatch(r.Header.Get("If-None-Match"), etag) {
+		h.Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	h.Set("Content-Length", strconv.Itoa(len(e.body)))
+	w.WriteHeader(e.status)
+	if r.Method != http.MethodHead {
+		w.Write(e.body)
+	}
+}
+
+// refreshKey marks the requests the cache sends on its own. They have no
+// client to pin to a backend or to hand cookies to.
+type refreshKey struct{}
+
+// isRefresh reports whether r is a background revalidation of the cache.
+func isRefresh(r *http.Request) bool {
+	v, _ := r.Context().Value(refreshKey{}).(bool)
+	return v
+}
+
+// revalidate refreshes a stale entry in the background, once at a time.
+func (c *Cache) revalidate(r *http.Request, e *cacheEntry) {
+	if !e.revalidating.CompareAndSwap(false, true) {
+		return
+	}
+	req := r.Clone(context.WithValue(context.WithoutCancel(r.Context()), refreshKey{}, true))
+	req.Method = http.MethodGet
+	go func() {
+		defer e.revalidating.Store(false)
+		c.fetch(nil, req, e)
+	}()
+}
+
+// fetch forwards r upstream, revalidating stale when it is set, and stores
+// the response if it may be cached. With a nil w nothing is sent to a client.
+func (c *Cache) fetch(w http.ResponseWriter, r *http.Request, stale *cacheEntry) {
+	req := r
+	conditional := false
+	if stale != nil && r.Header.Get("If-None-Match") == "" && r.Header.Get("If-Modified-Since") == "" {
+		etag, modified := stale.header.Get("ETag"), stale.header.Get("Last-Modified")
+		if etag != "" || modified != "" {
+			req = r.Clone(r.Context())
+			if etag != "" {
+				req.Header.Set("If-None-Match", etag)
+			}
+			if modified != "" {
+				req.Header.Set("If-Modified-Since", modified)
+			}
+			conditional = true
+		}
+	}
+
+	now := time.Now()
+	cw := &captureWriter{w: w, header: make(http.Header), limit: c.cfg.MaxEntryBytes}
+	cw.deliver = func(status int) bool {
+		switch {
+		case w == nil:
+			return false
+		case stale != nil && status == http.StatusNotModified && conditional:
+			return false
+		case stale != nil && status >= 500 && stale.age(now) < stale.fresh+stale.sie:
+			return false
+		}
+		w.Header().Set("X-Cache", "MISS")
+		return true
+	}
+	c.next.ServeHTTP(cw, req)
+	if cw.status == 0 {
+		return
+	}
+
+	switch {
+	case stale != nil && cw.status == http.StatusNotModified && conditional:
+		e := c.refresh(stale, cw.header, now)
+		if w != nil {
+			c.serve(w, r, e, time.Now())
+		}
+	case !cw.delivered && stale != nil && cw.status >= 500:
+		// stale-if-error
+		if w != nil {
+			c.serve(w, r, stale, time.Now())
+		}
+	case r.Method == http.MethodGet && !cw.overflow:
+		if e := c.newEntry(r, cw, now, stale); e != nil {
+			c.put(e)
+		} else if stale != nil {
+			c.invalidate(stale.key)
+		}
+	}
+}
+
+// newEntry builds the entry for a response, or returns nil if it may not
+// be stored.
+func (c *Cache) newEntry(r *http.Request, cw *captureWriter, now time.Time, prev *cacheEntry) *cacheEntry {
+	h := cw.header
+	cc := parseCacheControl(h)
+	if !cacheableStatus[cw.status] || h.Get("Set-Cookie") != "" {
+		return nil
+	}
+	if has(cc, "no-store") || has(cc, "private") {
+		return nil
+	}
+	if r.Header.Get("Cookie") != "" && !has(cc, "public") {
+		// a response to a request with cookies (e.g. a login session) may be
+		// personal unless the upstream says otherwise
+		return nil
+	}
+	var vary []string
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name == "*" {
+				return nil
+			} else if name != "" {
+				vary = append(vary, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+
+	e := &cacheEntry{
+		key:    cacheKey(r),
+		uri:    r.URL.RequestURI(),
+		vary:   vary,
+		status: cw.status,
+		header: h.Clone(),
+		body:   append([]byte(nil), cw.body.Bytes()...),
+		stored: now,
+		public: has(cc, "public"),
+	}
+	e.header.Del("Content-Length")
+	for _, name := range vary {
+		e.varyValues = append(e.varyValues, strings.Join(r.Header.Values(name), ","))
+	}
+	e.fresh, e.swr, e.sie = c.lifetimes(h, cc, now)
+	if age, err := strconv.Atoi(h.Get("Age")); err == nil && age > 0 {
+		e.initialAge = time.Duration(age) * time.Second
+	}
+	validators := h.Get("ETag") != "" || h.Get("Last-Modified") != ""
+	if e.fresh <= 0 && !validators {
+		return nil
+	}
+	if prev != nil {
+		e.revalidating = prev.revalidating
+	} else {
+		e.revalidating = new(atomic.Bool)
+	}
+	return e
+}
+
+// lifetimes returns the freshness lifetime and the stale-while-revalidate
+// and stale-if-error windows of a response.
+func (c *Cache) lifetimes(h http.Header, cc map[string]string, now time.Time) (fresh, swr, sie time.Duration) {
+	switch {
+	case has(cc, "no-cache"):
+		fresh = 0
+	case has(cc, "s-maxage"):
+		fresh = seconds(cc["s-maxage"])
+	case has(cc, "max-age"):
+		fresh = seconds(cc["max-age"])
+	case h.Get("Expires") != "":
+		if exp, err := http.ParseTime(h.Get("Expires")); err == nil {
+			date := now
+			if d, err := http.ParseTime(h.Get("Date")); err == nil {
+				date = d
+			}
+			fresh = exp.Sub(date)
+		}
+	default:
+		fresh = c.cfg.DefaultTTL
+	}
+
+	swr, sie = c.cfg.StaleWhileRevalidate, c.cfg.StaleIfError
+	if has(cc, "stale-while-revalidate") {
+		swr = seconds(cc["stale-while-revalidate"])
+	}
+	if has(cc, "stale-if-error") {
+		sie = seconds(cc["stale-if-error"])
+	}
+	if has(cc, "must-revalidate") || has(cc, "proxy-revalidate") {
+		swr, sie = 0, 0
+	}
+	return fresh, swr, sie
+}
+
+// refresh stores stale again with the headers of a 304 response.
+func (c *Cache) refresh(stale *cacheEntry, h http.Header, now time.Time) *cacheEntry {
+	e := *stale
+	e.header = stale.header.Clone()
+	for k, vs := range h {
+		if k != "Content-Length" {
+			e.header[k] = vs
+		}
+	}
+	e.stored, e.initialAge = now, 0
+	e.fresh, e.swr, e.sie = c.lifetimes(e.header, parseCacheControl(e.header), now)
+	c.put(&e)
+	return &e
+}
+
+// get returns the variant stored for the request, if any.
+func (c *Cache) get(key string, r *http.Request) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, el := range c.index[key] {
+		if e := el.Value.(*cacheEntry); e.matches(r) {
+			c.lru.MoveToFront(el)
+			return e
+		}
+	}
+	return nil
+}
+
+// put stores e, replacing the same variant and evicting the least recently
+// used entries beyond the limits.
+func (c *Cache) put(e *cacheEntry) {
+	size := e.size()
+	if size > c.cfg.MaxEntryBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, el := range c.index[e.key] {
+		if old := el.Value.(*cacheEntry); strings.Join(old.varyValues, "\x00") == strings.Join(e.varyValues, "\x00") {
+			c.remove(el)
+			break
+		}
+	}
+	c.index[e.key] = append(c.index[e.key], c.lru.PushFront(e))
+	c.bytes += size
+	for c.lru.Len() > c.cfg.MaxEntries || c.bytes > c.cfg.MaxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove drops an element. Callers hold mu.
+func (c *Cache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*cacheEntry)
+	c.bytes -= e.size()
+	variants := c.index[e.key]
+	for i, v := range variants {
+		if v == el {
+			variants = append(variants[:i], variants[i+1:]...)
+			break
+		}
+	}
+	if len(variants) == 0 {
+		delete(c.index, e.key)
+	} else {
+		c.index[e.key] = variants
+	}
+}
+
+func (c *Cache) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.index[key]) > 0 {
+		c.remove(c.index[key][0])
+	}
+}
+
+// Purge removes the entries whose request URI starts with prefix (all of
+// them for an empty prefix) and returns how many were removed.
+func (c *Cache) Purge(prefix string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+		if strings.HasPrefix(el.Value.(*cacheEntry).uri, prefix) {
+			c.remove(el)
+			n++
+		}
+		el = next
+	}
+	return n
+}
+
+// captureWriter buffers an upstream response. deliver decides, once the
+// status is known, whether the response also goes to the client.
+type captureWriter struct {
+	w       http.ResponseWriter
+	header  http.Header
+	deliver func(status int) bool
+	limit   int
+
+	status    int
+	delivered bool
+	body      bytes.Buffer
+	overflow  bool // body exceeded limit and was not kept
+}
+
+func (cw *captureWriter) Header() http.Header { return cw.header }
+
+func (cw *captureWriter) WriteHeader(code int) {
+	if cw.status != 0 || code < 200 {
+		return
+	}
+	cw.status = code
+	if !cw.deliver(code) {
+		return
+	}
+	cw.delivered = true
+	h := cw.w.Header()
+	for k, vs := range cw.header {
+		h[k] = vs
+	}
+	cw.w.WriteHeader(code)
+}
+
+func (cw *captureWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.overflow {
+		if cw.body.Len()+len(p) > cw.limit {
+			cw.overflow = true
+			cw.body = bytes.Buffer{}
+		} else {
+			cw.body.Write(p)
+		}
+	}
+	if cw.delivered {
+		return cw.w.Write(p)
+	}
+	return len(p), nil
+}
+
+// Flush streams what was delivered so far.
+func (cw *captureWriter) Flush() {
+	if cw.delivered {
+		http.NewResponseController(cw.w).Flush()
+	}
+}
+
+// parseCacheControl returns the Cache-Control directives of h, with
+// lower-case names and unquoted values.
+func parseCacheControl(h http.Header) map[string]string {
+	cc := make(map[string]string)
+	for _, v := range h.Values("Cache-Control") {
+		for _, part := range strings.Split(v, ",") {
+			name, val, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name != "" {
+				cc[strings.ToLower(name)] = strings.Trim(val, `"`)
+			}
+		}
+	}
+	return cc
+}
+
+func has(cc map[string]string, name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+// seconds parses a delta-seconds directive value; invalid values are 0.
+func seconds(v string) time.Duration {
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return time.Duration(n) * time.Second
+}
+
+// etagMatch reports whether an If-None-Match header lists etag.
+func etagMatch(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	weak := func(s string) string { return strings.TrimPrefix(s, "W/") }
+	for _, t := range strings.Split(header, ",") {
+		if t = strings.TrimSpace(t); t == "*" || weak(t) == weak(etag) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,274 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/RafaelZelak/gateway/internal/config"
+)
+
+// origin answers every call with respond; calls counts them.
+type origin struct {
+	calls   atomic.Int32
+	respond func(call int, w http.ResponseWriter, r *http.Request)
+}
+
+func (o *origin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	o.respond(int(o.calls.Add(1)), w, r)
+}
+
+// waitCalls waits until the origin has been called n times, for background
+// revalidations.
+func (o *origin) waitCalls(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for int(o.calls.Load()) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("origin called %d times, want %d", o.calls.Load(), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	// let the refresh be stored
+	time.Sleep(20 * time.Millisecond)
+}
+
+// cacheStep is one request through the cache and what it should get back.
+type cacheStep struct {
+	header    map[string]string
+	waitCalls int // origin calls to wait for first
+	status    int
+	xcache    string
+	body      string
+}
+
+func TestCache(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		respond func(call int, w http.ResponseWriter, r *http.Request)
+		steps   []cacheStep
+	}{
+		{
+			name: "fresh response is served from memory",
+			respond: func(call int, w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Cache-Control", "max-age=60")
+				fmt.Fprintf(w, "v%d", call)
+			},
+			steps: []cacheStep{
+				{status: 200, xcache: "MISS", body: "v1"},
+				{status: 200, xcache: "HIT", body: "v1"},
+			},
+		},
+		{
+			name: "expired response goes back to the origin",
+			respond: func(call int, w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Cache-Control", "max-age=60")
+				w.Header().Set("Age", "120")
+				fmt.Fprintf(w, "v%d", call)
+			},
+			steps: []cacheStep{
+				{status: 200, xcache: "MISS", body: "v1"},
+				{status: 200, xcache: "MISS", body: "v2"},
+			},
+		},
+		{
+			name: "no-store is never cached",
+			respond: func(call int, w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Cache-Control", "no-store")
+				fmt.Fprintf(w, "v%d", call)
+			},
+			steps: []cacheStep{
+				{status: 200, xcache: "MISS", body: "v1"},
+				{status: 200, xcache: "MISS", body: "v2"},
+			},
+		},
+		{
+			name: "Vary selects the variant",
+			respond: func(call int, w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Cache-Control", "max-age=60")
+				w.Header().Set("Vary", "Accept-Language")
+				fmt.Fprintf(w, "%s v%d", r.Header.Get("Accept-Language"), call)
+			},
+			steps: []cacheStep{
+				{header: map[string]string{"Accept-Language": "pt"}, status: 200, xcache: "MISS", body: "pt v1"},
+				{header: map[string]string{"Accept-Language": "en"}, status: 200, xcache: "MISS", body: "en v2"},
+				{header: map[string]string{"Accept-Language": "pt"}, status: 200, xcache: "HIT", body: "pt v1"},
+				{header: map[string]string{"Accept-Language": "en"}, status: 200, xcache: "HIT", body: "en v2"},
+			},
+		},
+		{
+			name: "client ETag gets a 304 from the stored copy",
+			respond: func(call int, w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Cache-Control", "max-age=60")
+				w.Header().Set("ETag", `"abc"`)
+				fmt.Fprintf(w, "v%d", call)
+			},
+			steps: []cacheStep{
+				{status: 200, xcache: "MISS", body: "v1"},
+				{header: map[string]string{"If-None-Match": `"abc"`}, status: 304, xcache: "HIT"},
+			},
+		},
+		{
+			name: "stale entry is revalidated with its ETag",
+			respond: func(call int, w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Cache-Control", "max-age=60")
+				w.Header().Set("ETag", `"abc"`)
+				if r.Header.Get("If-None-Match") == `"abc"` {
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+				w.Header().Set("Age", "120")
+				fmt.Fprintf(w, "v%d", call)
+			},
+			steps: []cacheStep{
+				{status: 200, xcache: "MISS", body: "v1"},
+				// the origin confirms v1, which is fresh again
+				{status: 200, xcache: "HIT", body: "v1"},
+				{status: 200, xcache: "HIT", body: "v1"},
+			},
+		},
+		{
+			name: "stale-while-revalidate serves the old copy and refreshes it",
+			respond: func(call int, w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Cache-Control", "max-age=60, stale-while-revalidate=120")
+				if call == 1 {
+					w.Header().Set("Age", "100")
+				}
+				fmt.Fprintf(w, "v%d", call)
+			},
+			steps: []cacheStep{
+				{status: 200, xcache: "MISS", body: "v1"},
+				{status: 200, xcache: "HIT", body: "v1"},
+				{waitCalls: 2, status: 200, xcache: "HIT", body: "v2"},
+			},
+		},
+		{
+			name: "stale-if-error hides an origin failure",
+			respond: func(call int, w http.ResponseWriter, r *http.Request) {
+				if call > 1 {
+					http.Error(w, "down", http.StatusBadGateway)
+					return
+				}
+				w.Header().Set("Cache-Control", "max-age=60, stale-if-error=300")
+				w.Header().Set("Age", "100")
+				fmt.Fprintf(w, "v%d", call)
+			},
+			steps: []cacheStep{
+				{status: 200, xcache: "MISS", body: "v1"},
+				{status: 200, xcache: "HIT", body: "v1"},
+			},
+		},
+		{
+			name: "failure past stale-if-error reaches the client",
+			respond: func(call int, w http.ResponseWriter, r *http.Request) {
+				if call > 1 {
+					http.Error(w, "down", http.StatusBadGateway)
+					return
+				}
+				w.Header().Set("Cache-Control", "max-age=60, stale-if-error=10")
+				w.Header().Set("Age", "100")
+				fmt.Fprintf(w, "v%d", call)
+			},
+			steps: []cacheStep{
+				{status: 200, xcache: "MISS", body: "v1"},
+				{status: 502, xcache: "MISS", body: "down\n"},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			o := &origin{respond: tc.respond}
+			c := NewCache(config.Cache{}, o)
+			for i, s := range tc.steps {
+				if s.waitCalls > 0 {
+					o.waitCalls(t, s.waitCalls)
+				}
+				req := httptest.NewRequest(http.MethodGet, "/page", nil)
+				for k, v := range s.header {
+					req.Header.Set(k, v)
+				}
+				rec := httptest.NewRecorder()
+				c.ServeHTTP(rec, req)
+				if rec.Code != s.status || rec.Header().Get("X-Cache") != s.xcache || rec.Body.String() != s.body {
+					t.Errorf("step %d: got %d %s %q, want %d %s %q", i,
+						rec.Code, rec.Header().Get("X-Cache"), rec.Body.String(), s.status, s.xcache, s.body)
+				}
+			}
+		})
+	}
+}
+
+func TestCachePurge(t *testing.T) {
+	o := &origin{respond: func(call int, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprintf(w, "%s v%d", r.URL.Path, call)
+	}}
+	c := NewCache(config.Cache{}, o)
+	get := func(path string) string {
+		rec := httptest.NewRecorder()
+		c.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Header().Get("X-Cache")
+	}
+	for _, p := range []string{"/a/1", "/a/2", "/b/1"} {
+		get(p)
+	}
+	if n := c.Purge("/a/"); n != 2 {
+		t.Errorf("Purge(/a/) removed %d entries, want 2", n)
+	}
+	for path, want := range map[string]string{"/a/1": "MISS", "/a/2": "MISS", "/b/1": "HIT"} {
+		if got := get(path); got != want {
+			t.Errorf("%s after purge: X-Cache = %s, want %s", path, got, want)
+		}
+	}
+	if n := c.Purge(""); n != 3 {
+		t.Errorf("Purge() removed %d entries, want 3", n)
+	}
+}
+
+func TestCacheRefreshThroughStickyPool(t *testing.T) {
+	var calls atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		call := calls.Add(1)
+		w.Header().Set("Cache-Control", "public, max-age=60, stale-while-revalidate=120")
+		if call == 1 {
+			w.Header().Set("Age", "100")
+		}
+		fmt.Fprintf(w, "v%d", call)
+	}))
+	defer backend.Close()
+
+	lb, err := BuildLoadBalancer([]config.Upstream{testUpstream("a", backend.URL)}, nil, NewDefaultTransport())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lb.Close()
+	lb.EnableSticky(config.StickySessions{}, "/")
+	c := NewCache(config.Cache{}, lb)
+
+	// a pinned client stores a stale copy
+	pinned := httptest.NewRequest(http.MethodGet, "/page", nil)
+	pinned.AddCookie(&http.Cookie{Name: "gateway_affinity", Value: lb.sticky.sign("a")})
+	c.ServeHTTP(httptest.NewRecorder(), pinned)
+
+	// a new client gets it and triggers the background refresh, which must
+	// not be pinned: the affinity cookie would make the refresh uncacheable
+	// and drop the entry
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/page", nil))
+	if rec.Header().Get("X-Cache") != "HIT" || rec.Body.String() != "v1" {
+		t.Fatalf("got %s %q, want the stale copy", rec.Header().Get("X-Cache"), rec.Body.String())
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for calls.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	rec = httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/page", nil))
+	if rec.Header().Get("X-Cache") != "HIT" || rec.Body.String() != "v2" {
+		t.Errorf("after refresh got %s %q, want HIT \"v2\"", rec.Header().Get("X-Cache"), rec.Body.String())
+	}
+}
@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RafaelZelak/gateway/internal/config"
+)
+
+// Coalescer collapses identical concurrent GET and HEAD requests: the
+// first one goes upstream and the others receive a copy of its response.
+// Requests carrying credentials (Authorization or Cookie) always make their
+// own upstream call.
+type Coalescer struct {
+	cfg  config.Coalesce
+	next http.Handler
+
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// call is an upstream request in flight that others may wait for.
+type call struct {
+	done   chan struct{}
+	leader http.Header // request headers of the request that went upstream
+	res    *sharedResponse
+}
+
+// sharedResponse is a buffered response that may be handed to followers.
+type sharedResponse struct {
+	status int
+	header http.Header
+	body   []byte
+	vary   []string
+}
+
+// NewCoalescer puts request coalescing in front of next.
+func NewCoalescer(cfg config.Coalesce, next http.Handler) *Coalescer {
+	return &Coalescer{cfg: cfg.WithDefaults(), next: next, calls: make(map[string]*call)}
+}
+
+func (c *Coalescer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead || credentialed(r) {
+		c.next.ServeHTTP(w, r)
+		return
+	}
+	key := r.Method + " " + cacheKey(r)
+
+	c.mu.Lock()
+	if cl, ok := c.calls[key]; ok {
+		c.mu.Unlock()
+		c.follow(w, r, cl)
+		return
+	}
+	cl := &call{done: make(chan struct{}), leader: r.Header.Clone()}
+	c.calls[key] = cl
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.calls, key)
+		c.mu.Unlock()
+		close(cl.done)
+	}()
+	cw := &captureWriter{w: w, header: make(http.Header), limit: c.cfg.MaxBodyBytes}
+	cw.deliver = func(int) bool { return true }
+	c.next.ServeHTTP(cw, r)
+	cl.res = share(cw)
+}
+
+// credentialed reports whether r identifies a user, in which case its
+// response may be personal and is never shared with other clients.
+func credentialed(r *http.Request) bool {
+	return r.Header.Get("Authorization") != "" || r.Header.Get("Cookie") != ""
+}
+
+// follow waits for the leader's response and copies it, or makes its own
+// upstream call if the leader failed, took too long or got a response that
+// does not apply to this request.
+func (c *Coalescer) follow(w http.ResponseWriter, r *http.Request, cl *call) {
+	t := time.NewTimer(c.cfg.MaxWait)
+	defer t.Stop()
+	select {
+	case <-cl.done:
+		if res := cl.res; res != nil && res.appliesTo(r, cl.leader) {
+			res.write(w, r)
+			return
+		}
+	case <-t.C:
+	case <-r.Context().Done():
+		return
+	}
+	c.next.ServeHTTP(w, r)
+}
+
+// share returns the captured response if other clients may receive it:
+// complete, not an upstream failure and not personal.
+func share(cw *captureWriter) *sharedResponse {
+	h := cw.header
+	if cw.status == 0 || cw.status >= 500 || cw.overflow || h.Get("Set-Cookie") != "" {
+		return nil
+	}
+	cc := parseCacheControl(h)
+	if has(cc, "private") || has(cc, "no-store") {
+		return nil
+	}
+	res := &sharedResponse{status: cw.status, header: h.Clone(), body: cw.body.Bytes()}
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name == "*" {
+				return nil
+			} else if name != "" {
+				res.vary = append(res.vary, name)
+			}
+		}
+	}
+	return res
+}
+
+// appliesTo reports whether r carries the same values as the leader for
+// every header the response varies on.
+func (res *sharedResponse) appliesTo(r *http.Request, leader http.Header) bool {
+	for _, name := range res.vary {
+		if strings.Join(r.Header.Values(name), ",") != strings.Join(leader.Values(name), ",") {
+			return false
+		}
+	}
+	return true
+}
+
+func (res *sharedResponse) write(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	for k, vs := range res.header {
+		h[k] = append([]string(nil), vs...)
+	}
+	if r.Method != http.MethodHead {
+		h.Set("Content-Length", strconv.Itoa(len(res.body)))
+	}
+	w.WriteHeader(res.status)
+	if r.Method != http.MethodHead {
+		w.Write(res.body)
+	}
+}
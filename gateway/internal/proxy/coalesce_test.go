@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/RafaelZelak/gateway/internal/config"
+)
+
+func TestCoalescerKeepsCredentialedRequestsApart(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	backend := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		w.Write([]byte("data for " + r.Header.Get("Authorization") + r.Header.Get("Cookie")))
+	})
+	c := NewCoalescer(config.Coalesce{MaxWait: time.Second}, backend)
+
+	creds := []http.Header{
+		{"Authorization": {"Bearer alice"}},
+		{"Authorization": {"Bearer bob"}},
+		{"Cookie": {"session_token=alice"}},
+		{"Cookie": {"session_token=bob"}},
+	}
+	bodies := make([]string, len(creds))
+	var wg sync.WaitGroup
+	for i, h := range creds {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := httptest.NewRequest(http.MethodGet, "/profile", nil)
+			r.Header = h
+			rec := httptest.NewRecorder()
+			c.ServeHTTP(rec, r)
+			bodies[i] = rec.Body.String()
+		}()
+	}
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != int32(len(creds)) {
+		t.Errorf("backend called %d times, want %d", n, len(creds))
+	}
+
+	for i, h := range creds {
+		want := "data for " + h.Get("Authorization") + h.Get("Cookie")
+		if bodies[i] != want {
+			t.Errorf("request %d got %q, want %q", i, bodies[i], want)
+		}
+	}
+}
+
+func TestCoalescerMergesAnonymousRequests(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	backend := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		w.Write([]byte("shared"))
+	})
+	c := NewCoalescer(config.Coalesce{MaxWait: time.Second}, backend)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/public", nil))
+		}()
+	}
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("backend called %d times, want 1", n)
+	}
+}
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/RafaelZelak/gateway/internal/config"
+)
+
+// StartHealthChecks probes every backend in the background until Close is
+// called, marking backends down after UnhealthyThreshold consecutive
+// failures and up again after HealthyThreshold consecutive successes.
+func (lb *LoadBalancer) StartHealthChecks(hc config.HealthCheck, transport http.RoundTripper) {
+	hc = hc.WithDefaults()
+	lb.checked = true
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   hc.Timeout,
+		// a redirect is an answer, do not follow it
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	for _, b := range lb.backends {
+		go lb.probeLoop(b, hc, client)
+	}
+}
+
+// probeLoop runs the checks of a single backend.
+func (lb *LoadBalancer) probeLoop(b *Backend, hc config.HealthCheck, client *http.Client) {
+	target := strings.TrimRight(b.URL.String(), "/") + hc.Path
+	ticker := time.NewTicker(hc.Interval)
+	defer ticker.Stop()
+
+	var successes, failures int
+	for {
+		err := probe(client, target, hc.ExpectedStatus)
+		if err == nil {
+			successes, failures = successes+1, 0
+			if !b.healthy.Load() && successes >= hc.HealthyThreshold {
+				b.healthy.Store(true)
+				log.Printf("[HEALTH] backend %s (%s) is up", b.Name, target)
+			}
+		} else {
+			successes, failures = 0, failures+1
+			if b.healthy.Load() && failures >= hc.UnhealthyThreshold {
+				b.healthy.Store(false)
+				log.Printf("[HEALTH] backend %s (%s) is down: %v", b.Name, target, err)
+			}
+		}
+
+		select {
+		case <-lb.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probe sends one GET and checks the status code.
+func probe(client *http.Client, target string, expected int) error {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	ok := resp.StatusCode >= 200 && resp.StatusCode < 400
+	if expected != 0 {
+		ok = resp.StatusCode == expected
+	}
+	if !ok {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/RafaelZelak/gateway/internal/config"
+)
+
+// MirrorHeader marks the copies sent to a mirror, so the shadow backend can
+// tell them apart from real traffic.
+const MirrorHeader = "X-Gateway-Mirror"
+
+// hopHeaders are connection-scoped and never copied to the mirror.
+var hopHeaders = []string{
+	"Connection", "Proxy-Connection", "Keep-Alive", "Proxy-Authenticate",
+	"Proxy-Authorization", "Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// Mirror replays a sample of the requests it serves to a shadow backend and
+// discards the responses. Copies run in the background with their own
+// transport, timeout and concurrency cap; the primary request never waits
+// for them.
+type Mirror struct {
+	cfg       config.Mirror
+	target    *url.URL
+	transport http.RoundTripper
+	slots     chan struct{}
+	logger    *log.Logger
+	next      http.Handler
+}
+
+// NewMirror wraps next so sampled requests are also sent to cfg.Target.
+// Results are written to logger.
+func NewMirror(cfg config.Mirror, logger *log.Logger, next http.Handler) (*Mirror, error) {
+	cfg = cfg.WithDefaults()
+	target, err := url.Parse(cfg.Target)
+	if err != nil {
+		return nil, err
+	}
+	return &Mirror{
+		cfg:    cfg,
+		target: target,
+		transport: NewTransport(config.Timeouts{
+			Connect:        cfg.Timeout,
+			ResponseHeader: cfg.Timeout,
+		}, nil),
+		slots:  make(chan struct{}, cfg.MaxConcurrent),
+		logger: logger,
+		next:   next,
+	}, nil
+}
+
+func (m *Mirror) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if m.sampled(r) {
+		if req, ok := m.copy(r); ok {
+			if r.Body == nil || r.Body == http.NoBody {
+				m.dispatch(req, nil)
+			} else {
+				// the copy leaves once the primary handler has read the body
+				r.Body = &teeBody{
+					ReadCloser: r.Body,
+					limit:      m.cfg.MaxBodyBytes,
+					done:       func(body []byte) { m.dispatch(req, body) },
+				}
+			}
+		}
+	}
+	m.next.ServeHTTP(w, r)
+}
+
+// sampled reports whether r is picked for mirroring.
+func (m *Mirror) sampled(r *http.Request) bool {
+	if r.Header.Get("Upgrade") != "" {
+		return false
+	}
+	s := *m.cfg.Sample
+	return s >= 1 || (s > 0 && rand.Float64() < s)
+}
+
+// copy builds the request sent to the mirror, without its body. It reports
+// false when the body is known to be too large to mirror.
+func (m *Mirror) copy(r *http.Request) (*http.Request, bool) {
+	if r.ContentLength > int64(m.cfg.MaxBodyBytes) {
+		return nil, false
+	}
+
+	u := *m.target
+	u.Path = singleJoiningSlash(m.target.Path, r.URL.Path)
+	u.RawPath = ""
+	u.RawQuery = r.URL.RawQuery
+
+	req, err := http.NewRequest(r.Method, u.String(), nil)
+	if err != nil {
+		return nil, false
+	}
+	req.Header = r.Header.Clone()
+	for _, h := range hopHeaders {
+		req.Header.Del(h)
+	}
+	appendForwardedFor(req.Header, r)
+	req.Header.Set(MirrorHeader, "1")
+	req.Host = r.Host
+	return req, true
+}
+
+// dispatch sends req with body to the mirror in the background, or drops it
+// when every slot is busy rather than queue it.
+func (m *Mirror) dispatch(req *http.Request, body []byte) {
+	select {
+	case m.slots <- struct{}{}:
+	default:
+		return
+	}
+	req.ContentLength = int64(len(body))
+	req.Body = http.NoBody
+	if body != nil {
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	go m.send(req)
+}
+
+// teeBody copies a request body as the primary handler reads it, so the
+// primary request never waits for the mirror. Once the body has been read to
+// the end, done gets the copy; a body over limit bytes, or one the handler
+// leaves unread, is not mirrored.
+type teeBody struct {
+	io.ReadCloser
+	buf   bytes.Buffer
+	limit int
+	done  func([]byte)
+}
+
+func (t *teeBody) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	if t.done == nil {
+		return n, err
+	}
+	if t.buf.Len()+n > t.limit {
+		t.done = nil
+		t.buf = bytes.Buffer{}
+		return n, err
+	}
+	t.buf.Write(p[:n])
+	if err == io.EOF {
+		done := t.done
+		t.done = nil
+		done(t.buf.Bytes())
+	}
+	return n, err
+}
+
+// send performs the mirrored request, drains and drops the response and logs
+// the outcome.
+func (m *Mirror) send(req *http.Request) {
+	defer func() { <-m.slots }()
+	ctx, cancel := context.WithTimeout(context.Background(), m.cfg.Timeout)
+	defer cancel()
+	req = req.WithContext(ctx)
+
+	start := time.Now()
+	resp, err := m.transport.RoundTrip(req)
+	if err != nil {
+		m.logger.Printf("[MIRROR] [%s] %s %s -> error: %v %v",
+			start.Format(time.RFC3339), req.Method, req.URL.RequestURI(), err, time.Since(start))
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	m.logger.Printf("[MIRROR] [%s] %s %s -> %d %v",
+		start.Format(time.RFC3339), req.Method, req.URL.RequestURI(), resp.StatusCode, time.Since(start))
+}
+
+// Close releases the mirror's idle connections.
+func (m *Mirror) Close() error {
+	if t, ok := m.transport.(interface{ CloseIdleConnections() }); ok {
+		t.CloseIdleConnections()
+	}
+	return nil
+}
+
+// singleJoiningSlash joins a target base path and a request path, as
+// httputil.NewSingleHostReverseProxy does.
+func singleJoiningSlash(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/RafaelZelak/gateway/internal/config"
+)
+
+// mirrored is what the shadow backend received.
+type mirrored struct {
+	body string
+	xff  string
+}
+
+func newShadow(t *testing.T) (*httptest.Server, chan mirrored) {
+	got := make(chan mirrored, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- mirrored{string(body), r.Header.Get("X-Forwarded-For")}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, got
+}
+
+func TestMirrorDoesNotWaitForTheBody(t *testing.T) {
+	shadow, got := newShadow(t)
+	entered := make(chan struct{})
+	primary := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		io.ReadAll(r.Body)
+	})
+	m, err := NewMirror(config.Mirror{Target: shadow.URL}, log.New(io.Discard, "", 0), primary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	// a slow upload: nothing of the body has been sent yet
+	pr, pw := io.Pipe()
+	req := httptest.NewRequest(http.MethodPost, "/upload", pr)
+	req.Header.Set("X-Forwarded-For", "10.0.0.1")
+	go m.ServeHTTP(httptest.NewRecorder(), req)
+
+	select {
+	case <-entered:
+	case <-time.After(time.Second):
+		t.Fatal("primary handler waited for the request body")
+	}
+	pw.Write([]byte("hello "))
+	pw.Write([]byte("mirror"))
+	pw.Close()
+
+	select {
+	case c := <-got:
+		if c.body != "hello mirror" {
+			t.Errorf("mirrored body = %q, want %q", c.body, "hello mirror")
+		}
+		if c.xff != "10.0.0.1, 192.0.2.1" {
+			t.Errorf("X-Forwarded-For = %q, want the client appended to the chain", c.xff)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("nothing was mirrored")
+	}
+}
+
+func TestMirrorSkipsLargeBodies(t *testing.T) {
+	shadow, got := newShadow(t)
+	primary := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { io.ReadAll(r.Body) })
+	m, err := NewMirror(config.Mirror{Target: shadow.URL, MaxBodyBytes: 4}, log.New(io.Discard, "", 0), primary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", io.NopCloser(io.LimitReader(neverEnding('x'), 10)))
+	m.ServeHTTP(httptest.NewRecorder(), req)
+	select {
+	case c := <-got:
+		t.Errorf("mirrored a body over maxBodyBytes: %q", c.body)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+type neverEnding byte
+
+func (b neverEnding) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(b)
+	}
+	return len(p), nil
+}
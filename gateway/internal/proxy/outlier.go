@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/RafaelZelak/gateway/internal/config"
+)
+
+// outlierState tracks the recent results of one backend.
+type outlierState struct {
+	mu          sync.Mutex
+	consecutive int
+	windowStart time.Time
+	total       int
+	errors      int
+	ejections   int
+}
+
+// ejected reports whether the backend is currently ejected.
+func (b *Backend) ejected(now time.Time) bool {
+	return now.UnixNano() < b.ejectedUntil.Load()
+}
+
+// EnableOutlierDetection makes the balancer eject backends whose real
+// traffic keeps failing, independently of active health checks.
+func (lb *LoadBalancer) EnableOutlierDetection(od config.OutlierDetection) {
+	od = od.WithDefaults()
+	lb.outlier = &od
+}
+
+// record feeds the outcome of one proxied request into outlier detection.
+func (lb *LoadBalancer) record(b *Backend, failed bool) {
+	od := lb.outlier
+	if od == nil {
+		return
+	}
+	now := time.Now()
+	st := &b.outlier
+
+	st.mu.Lock()
+	if now.Sub(st.windowStart) >= od.Window {
+		st.windowStart, st.total, st.errors = now, 0, 0
+	}
+	st.total++
+	if failed {
+		st.errors++
+		st.consecutive++
+	} else {
+		st.consecutive = 0
+	}
+	var reason string
+	switch {
+	case st.consecutive >= od.ConsecutiveErrors:
+		reason = fmt.Sprintf("%d consecutive errors", st.consecutive)
+	case od.ErrorRate > 0 && st.total >= od.MinRequests && float64(st.errors)/float64(st.total) > od.ErrorRate:
+		reason = fmt.Sprintf("%d of %d requests failed within %v", st.errors, st.total, od.Window)
+	}
+	st.mu.Unlock()
+
+	if reason != "" {
+		lb.eject(b, reason, now)
+	}
+}
+
+// eject removes b from rotation for a period that grows with each
+// ejection, unless that would exceed MaxEjectionPercent of the pool.
+func (lb *LoadBalancer) eject(b *Backend, reason string, now time.Time) {
+	od := lb.outlier
+	lb.ejectMu.Lock()
+	defer lb.ejectMu.Unlock()
+
+	if b.ejected(now) {
+		return
+	}
+	ejected := 0
+	for _, other := range lb.backends {
+		if other.ejected(now) {
+			ejected++
+		}
+	}
+	if (ejected+1)*100 > od.MaxEjectionPercent*len(lb.backends) {
+		log.Printf("[OUTLIER] backend %s not ejected (%s): max ejection percent reached", b.Name, reason)
+		return
+	}
+
+	st := &b.outlier
+	st.mu.Lock()
+	// an ejection long past no longer counts towards the back-off
+	if last := time.Unix(0, b.ejectedUntil.Load()); st.ejections > 0 && now.Sub(last) > od.MaxEjection {
+		st.ejections = 0
+	}
+	st.ejections++
+	d := od.BaseEjection * time.Duration(st.ejections)
+	if d > od.MaxEjection {
+		d = od.MaxEjection
+	}
+	st.consecutive, st.total, st.errors, st.windowStart = 0, 0, 0, now
+	st.mu.Unlock()
+
+	b.ejectedUntil.Store(now.Add(d).UnixNano())
+	log.Printf("[OUTLIER] backend %s ejected for %v: %s", b.Name, d, reason)
+}
+
+// statusRecorder remembers the final status code written to the client.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 && code >= 200 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer (Flush).
+func (s *statusRecorder) Unwrap() http.ResponseWriter { return s.ResponseWriter }
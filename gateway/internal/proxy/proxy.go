@@ -1,8 +1,7 @@
 package proxy
 
 import (
-	"log"
-	"math/rand"
+	"crypto/tls"
 	"net"
 	"net/http"
 	"net/http/httputil"
@@ -10,17 +9,36 @@ import (
 	"strings"
 	"time"
 
-	"github.com/gorilla/websocket"
+	"github.com/RafaelZelak/gateway/internal/config"
 	"golang.org/x/net/http2"
 )
 
 // NewDefaultTransport returns an HTTP/2-capable transport for REST proxying.
 func NewDefaultTransport() http.RoundTripper {
+	return NewTransport(config.Timeouts{}, nil)
+}
+
+// NewTransport returns an HTTP/2-capable transport using the given connect,
+// response header and idle timeouts. A 5s connect and a 90s idle timeout
+// apply when unset; a nil tlsConfig uses the Go defaults for https://
+// upstreams.
+func NewTransport(t config.Timeouts, tlsConfig *tls.Config) http.RoundTripper {
+	connect := t.Connect
+	if connect <= 0 {
+		connect = 5 * time.Second
+	}
+	idle := t.Idle
+	if idle <= 0 {
+		idle = 90 * time.Second
+	}
 	tr := &http.Transport{
-		Proxy:               http.ProxyFromEnvironment,
-		DialContext:         (&net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
-		ForceAttemptHTTP2:   true,
-		TLSHandshakeTimeout: 10 * time.Second,
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: connect, KeepAlive: 30 * time.Second}).DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: t.ResponseHeader,
+		IdleConnTimeout:       idle,
+		TLSClientConfig:       tlsConfig.Clone(),
 	}
 	_ = http2.ConfigureTransport(tr)
 	return tr
@@ -34,77 +52,6 @@ func BuildReverseProxy(target string, transport http.RoundTripper) (*httputil.Re
 	}
 	p := httputil.NewSingleHostReverseProxy(u)
 	p.Transport = transport
+	p.ErrorHandler = proxyError
 	return p, nil
 }
-
-// BuildLoadBalancer creates a simple round-robin proxy for multiple HTTP targets.
-func BuildLoadBalancer(targets []string, transport http.RoundTripper) http.Handler {
-	var proxies []*httputil.ReverseProxy
-	for _, t := range targets {
-		u, err := url.Parse(strings.TrimSpace(t))
-		if err != nil {
-			log.Fatalf("Invalid backend URL %s: %v", t, err)
-		}
-		p := httputil.NewSingleHostReverseProxy(u)
-		p.Transport = transport
-		proxies = append(proxies, p)
-	}
-	rand.Seed(time.Now().UnixNano())
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		idx := rand.Intn(len(proxies))
-		proxies[idx].ServeHTTP(w, r)
-	})
-}
-
-// NewWebSocketProxyHandler proxies WebSocket connections between client and backend.
-func NewWebSocketProxyHandler(prefix, target string) http.Handler {
-	upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
-
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		clientConn, err := upgrader.Upgrade(w, r, nil)
-		if err != nil {
-			log.Printf("WebSocket upgrade error: %v", err)
-			return
-		}
-		defer clientConn.Close()
-
-		backendURL := "ws://" + strings.TrimPrefix(target, "ws://") + r.URL.Path
-		backendConn, _, err := websocket.DefaultDialer.Dial(backendURL, nil)
-		if err != nil {
-			log.Printf("WebSocket backend dial error: %v", err)
-			return
-		}
-		defer backendConn.Close()
-
-		errc := make(chan error, 2)
-
-		go func() {
-			for {
-				mt, msg, err := clientConn.ReadMessage()
-				if err != nil {
-					errc <- err
-					return
-				}
-				if err := backendConn.WriteMessage(mt, msg); err != nil {
-					errc <- err
-					return
-				}
-			}
-		}()
-		go func() {
-			for {
-				mt, msg, err := backendConn.ReadMessage()
-				if err != nil {
-					errc <- err
-					return
-				}
-				if err := clientConn.WriteMessage(mt, msg); err != nil {
-					errc <- err
-					return
-				}
-			}
-		}()
-
-		<-errc
-	})
-}
@@ -0,0 +1,265 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/RafaelZelak/gateway/internal/config"
+	"github.com/RafaelZelak/gateway/pkg/httperr"
+)
+
+// RetryBudget caps retries across every balancer of a router, so a failing
+// backend cannot multiply the load on the rest of the gateway.
+type RetryBudget struct {
+	ratio float64
+	min   int
+
+	mu      sync.Mutex
+	buckets []budgetBucket // one per second of the window
+}
+
+type budgetBucket struct {
+	sec      int64
+	requests int
+	retries  int
+}
+
+// NewRetryBudget creates a budget; nil uses the defaults.
+func NewRetryBudget(cfg *config.RetryBudget) *RetryBudget {
+	var rb config.RetryBudget
+	if cfg != nil {
+		rb = *cfg
+	}
+	rb = rb.WithDefaults()
+	n := int(rb.Window / time.Second)
+	if n < 1 {
+		n = 1
+	}
+	return &RetryBudget{ratio: rb.Ratio, min: rb.MinRetries, buckets: make([]budgetBucket, n)}
+}
+
+// bucket returns the counters of second sec, clearing stale ones. Callers
+// hold mu.
+func (b *RetryBudget) bucket(sec int64) *budgetBucket {
+	bk := &b.buckets[sec%int64(len(b.buckets))]
+	if bk.sec != sec {
+		*bk = budgetBucket{sec: sec}
+	}
+	return bk
+}
+
+// request counts an incoming request towards the budget.
+func (b *RetryBudget) request() {
+	b.mu.Lock()
+	b.bucket(time.Now().Unix()).requests++
+	b.mu.Unlock()
+}
+
+// withdraw reserves one retry, reporting false when the budget is spent.
+func (b *RetryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now().Unix()
+	var requests, retries int
+	for _, bk := range b.buckets {
+		if now-bk.sec < int64(len(b.buckets)) {
+			requests += bk.requests
+			retries += bk.retries
+		}
+	}
+	if float64(retries) >= float64(b.min)+b.ratio*float64(requests) {
+		return false
+	}
+	b.bucket(now).retries++
+	return true
+}
+
+// retryPolicy is the resolved retry configuration of a balancer.
+type retryPolicy struct {
+	config.Retry
+	retryOn map[int]bool
+	budget  *RetryBudget
+}
+
+// EnableRetries re-sends failed requests to other backends of the pool,
+// drawing every retry from budget.
+func (lb *LoadBalancer) EnableRetries(rt config.Retry, budget *RetryBudget) {
+	p := &retryPolicy{Retry: rt.WithDefaults(), retryOn: make(map[int]bool), budget: budget}
+	for _, code := range p.RetryOn {
+		p.retryOn[code] = true
+	}
+	lb.retry = p
+	for _, b := range lb.backends {
+		b.proxy.ModifyResponse = retryResponse
+		b.proxy.ErrorHandler = retryError
+	}
+}
+
+// backoff returns the jittered delay before the given retry (1-based).
+func (p *retryPolicy) backoff(retry int) time.Duration {
+	d := p.Backoff << (retry - 1)
+	if d <= 0 || d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	// somewhere between half and the full delay
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// errRetryStatus hands a response with a retryable status to retryError.
+var errRetryStatus = errors.New("retryable upstream status")
+
+type attemptKey struct{}
+
+// attempt tracks one try of a request against a backend.
+type attempt struct {
+	policy     *retryPolicy
+	idempotent bool
+	last       bool        // no retry may follow this attempt
+	sent       atomic.Bool // the request headers reached the backend
+	failed     bool        // the failure was swallowed so the caller retries
+}
+
+// request returns r bound to the attempt, with a fresh copy of body.
+func (a *attempt) request(r *http.Request, body []byte) *http.Request {
+	ctx := context.WithValue(r.Context(), attemptKey{}, a)
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		WroteHeaders: func() { a.sent.Store(true) },
+	})
+	req := r.WithContext(ctx)
+	if body != nil {
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+	}
+	return req
+}
+
+// retry decides whether the failure of this attempt is retried, taking the
+// retry from the budget if so.
+func (a *attempt) retry(ctx context.Context, safe bool) bool {
+	if a.last || !safe || ctx.Err() != nil {
+		return false
+	}
+	return a.policy.budget.withdraw()
+}
+
+func attemptFrom(ctx context.Context) *attempt {
+	a, _ := ctx.Value(attemptKey{}).(*attempt)
+	return a
+}
+
+// retryResponse is the ModifyResponse hook of backends with retries: it
+// turns a retryable status into an error so the response is discarded.
+func retryResponse(resp *http.Response) error {
+	a := attemptFrom(resp.Request.Context())
+	if a == nil || !a.policy.retryOn[resp.StatusCode] {
+		return nil
+	}
+	if a.retry(resp.Request.Context(), a.idempotent) {
+		a.failed = true
+		return errRetryStatus
+	}
+	return nil
+}
+
+// retryError is the ErrorHandler of backends with retries. A failure that
+// will be retried is not written; otherwise it is reported by proxyError.
+func retryError(w http.ResponseWriter, r *http.Request, err error) {
+	if a := attemptFrom(r.Context()); a != nil {
+		// a request that never reached the backend is safe to send again
+		if a.failed || a.retry(r.Context(), a.idempotent || !a.sent.Load()) {
+			a.failed = true
+			return
+		}
+	}
+	proxyError(w, r, err)
+}
+
+// isIdempotent reports whether r may be sent twice, following the same
+// rules as net/http's transport.
+func isIdempotent(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	_, key := r.Header["Idempotency-Key"]
+	_, xkey := r.Header["X-Idempotency-Key"]
+	return key || xkey
+}
+
+// bufferBody reads a request body of up to limit bytes so it can be replayed.
+// It reports false, leaving r.Body intact, when the body is larger.
+func bufferBody(r *http.Request, limit int) ([]byte, bool, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true, nil
+	}
+	if r.ContentLength > int64(limit) {
+		return nil, false, nil
+	}
+	buf, err := io.ReadAll(io.LimitReader(r.Body, int64(limit)+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if len(buf) > limit {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf), r.Body), r.Body}
+		return nil, false, nil
+	}
+	return buf, true, nil
+}
+
+// serveWithRetries proxies r, moving to another backend after a retryable
+// failure until the attempts or the budget run out.
+func (lb *LoadBalancer) serveWithRetries(w http.ResponseWriter, r *http.Request) {
+	p := lb.retry
+	p.budget.request()
+
+	body, replayable, err := bufferBody(r, p.MaxBodySize)
+	if err != nil {
+		httperr.Write(w, r, http.StatusBadRequest, httperr.CodeBadRequest, "could not read request body")
+		return
+	}
+	attempts := p.Attempts
+	if !replayable {
+		attempts = 1
+	}
+	idempotent := isIdempotent(r)
+
+	var tried []*Backend
+	for n := 1; ; n++ {
+		if n > 1 {
+			t := time.NewTimer(p.backoff(n - 1))
+			select {
+			case <-r.Context().Done():
+				t.Stop()
+				if errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+					httperr.Write(w, r, http.StatusGatewayTimeout, httperr.CodeUpstreamTimeout, "upstream timed out")
+				}
+				return
+			case <-t.C:
+			}
+		}
+		b := lb.choose(w, r, tried)
+		if b == nil {
+			lb.unavailable(w, r)
+			return
+		}
+		tried = append(tried, b)
+
+		a := &attempt{policy: p, idempotent: idempotent, last: n >= attempts}
+		lb.forward(b, w, a.request(r, body))
+		if !a.failed {
+			return
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RafaelZelak/gateway/internal/config"
+)
+
+func TestRetryBudget(t *testing.T) {
+	b := NewRetryBudget(&config.RetryBudget{Ratio: 0.2, MinRetries: 2, Window: 10 * time.Second})
+	for i := 0; i < 10; i++ {
+		b.request()
+	}
+	// 2 plus 20% of 10 requests
+	for i := 0; i < 4; i++ {
+		if !b.withdraw() {
+			t.Fatalf("retry %d refused, want 4 allowed", i+1)
+		}
+	}
+	if b.withdraw() {
+		t.Error("fifth retry allowed, want the budget spent")
+	}
+	b.request()
+	b.request()
+	b.request()
+	b.request()
+	b.request()
+	if !b.withdraw() {
+		t.Error("retry refused after 5 more requests, want one more allowed")
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	p := &retryPolicy{Retry: config.Retry{Backoff: 100 * time.Millisecond, MaxBackoff: time.Second}}
+	for _, tc := range []struct {
+		retry    int
+		min, max time.Duration
+	}{
+		{1, 50 * time.Millisecond, 100 * time.Millisecond},
+		{2, 100 * time.Millisecond, 200 * time.Millisecond},
+		{4, 400 * time.Millisecond, 800 * time.Millisecond},
+		{5, 500 * time.Millisecond, time.Second},  // capped at maxBackoff
+		{80, 500 * time.Millisecond, time.Second}, // the shift overflows
+	} {
+		for i := 0; i < 50; i++ {
+			if d := p.backoff(tc.retry); d < tc.min || d > tc.max {
+				t.Fatalf("backoff(%d) = %v, want between %v and %v", tc.retry, d, tc.min, tc.max)
+			}
+		}
+	}
+}
+
+func TestIsIdempotent(t *testing.T) {
+	for _, tc := range []struct {
+		method, header string
+		want           bool
+	}{
+		{http.MethodGet, "", true},
+		{http.MethodPut, "", true},
+		{http.MethodDelete, "", true},
+		{http.MethodPost, "", false},
+		{http.MethodPatch, "", false},
+		{http.MethodPost, "Idempotency-Key", true},
+		{http.MethodPost, "X-Idempotency-Key", true},
+	} {
+		r := httptest.NewRequest(tc.method, "/", nil)
+		if tc.header != "" {
+			r.Header.Set(tc.header, "k1")
+		}
+		if got := isIdempotent(r); got != tc.want {
+			t.Errorf("%s with %q: idempotent = %v, want %v", tc.method, tc.header, got, tc.want)
+		}
+	}
+}
+
+// retryPool is a round-robin pool of a backend answering 503 and one
+// answering 200 "ok".
+func retryPool(t *testing.T, budget *RetryBudget) *LoadBalancer {
+	t.Helper()
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(append([]byte("ok"), body...))
+	}))
+	t.Cleanup(bad.Close)
+	t.Cleanup(good.Close)
+	lb, err := BuildLoadBalancer([]config.Upstream{
+		testUpstream("bad", bad.URL),
+		testUpstream("good", good.URL),
+	}, &config.LoadBalancing{Strategy: config.StrategyRoundRobin}, NewDefaultTransport())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lb.Close() })
+	lb.EnableRetries(config.Retry{Backoff: time.Millisecond}, budget)
+	return lb
+}
+
+func TestRetriesMoveToAnotherBackend(t *testing.T) {
+	lb := retryPool(t, NewRetryBudget(nil))
+	for i := 0; i < 4; i++ {
+		rec := httptest.NewRecorder()
+		lb.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/", strings.NewReader(" body")))
+		if rec.Code != http.StatusOK || rec.Body.String() != "ok body" {
+			t.Errorf("PUT %d: got %d %q, want the body replayed to the good backend", i, rec.Code, rec.Body.String())
+		}
+	}
+
+	// a POST that reached the backend is not sent twice
+	codes := map[int]int{}
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		lb.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("x")))
+		codes[rec.Code]++
+	}
+	if codes[http.StatusServiceUnavailable] != 1 || codes[http.StatusOK] != 1 {
+		t.Errorf("POST statuses = %v, want one 503 and one 200", codes)
+	}
+}
+
+func TestRetriesStopWhenBudgetIsSpent(t *testing.T) {
+	lb := retryPool(t, NewRetryBudget(&config.RetryBudget{Ratio: 0.001, MinRetries: 1}))
+	codes := map[int]int{}
+	for i := 0; i < 4; i++ {
+		rec := httptest.NewRecorder()
+		lb.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		codes[rec.Code]++
+	}
+	// the bad backend is tried first twice; only the first one is retried
+	if codes[http.StatusServiceUnavailable] != 1 || codes[http.StatusOK] != 3 {
+		t.Errorf("statuses = %v, want one 503 once the budget is spent", codes)
+	}
+}
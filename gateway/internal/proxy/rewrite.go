@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/RafaelZelak/gateway/internal/config"
+)
+
+type rewriteRule struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+// PathRewriter changes the request path before it reaches an HTTP or
+// WebSocket proxy: strip a prefix, apply regex rules in order, then add a
+// prefix.
+type PathRewriter struct {
+	strip string
+	add   string
+	rules []rewriteRule
+}
+
+// NewPathRewriter compiles the rewrite settings of a service. It returns nil
+// when the service does not rewrite paths.
+func NewPathRewriter(svc config.ServiceConfig) (*PathRewriter, error) {
+	if svc.StripPrefix == "" && svc.AddPrefix == "" && len(svc.Rewrite) == 0 {
+		return nil, nil
+	}
+	pr := &PathRewriter{
+		strip: strings.TrimRight(svc.StripPrefix, "/"),
+		add:   strings.TrimRight(svc.AddPrefix, "/"),
+	}
+	for _, rule := range svc.Rewrite {
+		re, err := regexp.Compile(rule.Regex)
+		if err != nil {
+			return nil, err
+		}
+		pr.rules = append(pr.rules, rewriteRule{re: re, replacement: rule.Replacement})
+	}
+	return pr, nil
+}
+
+// rewrite returns the new path and the prefix that was stripped, if any.
+// It works on the escaped path, so encoded characters such as %2F stay
+// encoded.
+func (pr *PathRewriter) rewrite(path string) (string, string) {
+	var stripped string
+	if pr.strip != "" && (path == pr.strip || strings.HasPrefix(path, pr.strip+"/")) {
+		path = strings.TrimPrefix(path, pr.strip)
+		stripped = pr.strip
+	}
+	for _, rule := range pr.rules {
+		path = rule.re.ReplaceAllString(path, rule.replacement)
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return pr.add + path, stripped
+}
+
+// Wrap returns a handler that rewrites the path and sets X-Forwarded-Prefix
+// to the stripped prefix so backends can build absolute links. A prefix sent
+// by the client is never passed on.
+func (pr *PathRewriter) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		escaped, stripped := pr.rewrite(r.URL.EscapedPath())
+		path, err := url.PathUnescape(escaped)
+		if err != nil {
+			// a replacement produced a stray %: take it literally
+			path, escaped = escaped, ""
+		}
+
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = path
+		r2.URL.RawPath = escaped
+		r2.Header = r.Header.Clone()
+		r2.Header.Del("X-Forwarded-Prefix")
+		if stripped != "" {
+			r2.Header.Set("X-Forwarded-Prefix", stripped)
+		}
+		next.ServeHTTP(w, r2)
+	})
+}
@@ -0,0 +1,56 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/RafaelZelak/gateway/internal/config"
+)
+
+func TestPathRewriterForwardedPrefix(t *testing.T) {
+	pr, err := NewPathRewriter(config.ServiceConfig{StripPrefix: "/api"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	h := pr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("X-Forwarded-Prefix")
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+	req.Header.Set("X-Forwarded-Prefix", "/evil")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if got != "/api" {
+		t.Errorf("X-Forwarded-Prefix = %q, want /api", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/other", nil)
+	req.Header.Set("X-Forwarded-Prefix", "/evil")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if got != "" {
+		t.Errorf("X-Forwarded-Prefix = %q without a stripped prefix, want none", got)
+	}
+}
+
+func TestPathRewriterKeepsEscapes(t *testing.T) {
+	pr, err := NewPathRewriter(config.ServiceConfig{
+		StripPrefix: "/files",
+		Rewrite:     []config.RewriteRule{{Regex: "^/v1/(.*)$", Replacement: "/v2/$1"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var path, escaped string
+	h := pr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, escaped = r.URL.Path, r.URL.EscapedPath()
+	}))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/files/v1/a%2Fb/c%20d", nil))
+	if escaped != "/v2/a%2Fb/c%20d" {
+		t.Errorf("escaped path = %q, want /v2/a%%2Fb/c%%20d", escaped)
+	}
+	if path != "/v2/a/b/c d" {
+		t.Errorf("path = %q, want /v2/a/b/c d", path)
+	}
+}
@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/RafaelZelak/gateway/internal/config"
+)
+
+// defaultStickySecret signs affinity cookies when no secret is configured.
+// It is shared by every router of the process so pins survive reloads.
+var defaultStickySecret = func() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}()
+
+// stickiness holds the affinity settings of a balancer.
+type stickiness struct {
+	cookie string
+	path   string
+	ttl    time.Duration
+	secret []byte
+}
+
+// EnableSticky pins clients to the backend that served their first request,
+// using a signed cookie scoped to route.
+func (lb *LoadBalancer) EnableSticky(st config.StickySessions, route string) {
+	s := &stickiness{cookie: st.Cookie, path: route, ttl: st.TTL, secret: []byte(st.Secret)}
+	if s.cookie == "" {
+		s.cookie = "gateway_affinity"
+	}
+	if s.path == "" {
+		s.path = "/"
+	}
+	if len(s.secret) == 0 {
+		s.secret = defaultStickySecret
+	}
+	lb.sticky = s
+}
+
+// sign returns the cookie value naming backend.
+func (s *stickiness) sign(backend string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(backend)) + "." + s.mac(backend)
+}
+
+func (s *stickiness) mac(backend string) string {
+	m := hmac.New(sha256.New, s.secret)
+	m.Write([]byte(s.path + "|" + backend))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+// pinned returns the backend name carried by a valid cookie.
+func (s *stickiness) pinned(r *http.Request) (string, bool) {
+	c, err := r.Cookie(s.cookie)
+	if err != nil {
+		return "", false
+	}
+	enc, sig, ok := strings.Cut(c.Value, ".")
+	if !ok {
+		return "", false
+	}
+	name, err := base64.RawURLEncoding.DecodeString(enc)
+	if err != nil || !hmac.Equal([]byte(sig), []byte(s.mac(string(name)))) {
+		return "", false
+	}
+	return string(name), true
+}
+
+// pin sets the affinity cookie for backend on the response, replacing the
+// one set for a previous attempt.
+func (s *stickiness) pin(w http.ResponseWriter, backend string) {
+	h := w.Header()
+	kept := h["Set-Cookie"][:0]
+	for _, v := range h["Set-Cookie"] {
+		if !strings.HasPrefix(v, s.cookie+"=") {
+			kept = append(kept, v)
+		}
+	}
+	if len(kept) == 0 {
+		h.Del("Set-Cookie")
+	} else {
+		h["Set-Cookie"] = kept
+	}
+	c := &http.Cookie{
+		Name:     s.cookie,
+		Value:    s.sign(backend),
+		Path:     s.path,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if s.ttl > 0 {
+		c.Expires = time.Now().Add(s.ttl)
+		c.MaxAge = int(s.ttl.Seconds())
+	}
+	http.SetCookie(w, c)
+}
+
+// pinnedBackend returns the backend the client is pinned to, with a slot
+// reserved, or nil if there is no valid pin or that backend is unavailable.
+func (lb *LoadBalancer) pinnedBackend(r *http.Request) *Backend {
+	name, ok := lb.sticky.pinned(r)
+	if !ok {
+		return nil
+	}
+	for _, b := range lb.backends {
+		if b.Name == name && b.available() && b.acquire() {
+			return b
+		}
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package proxy
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/RafaelZelak/gateway/internal/config"
+)
+
+// Balancer chooses one of the available candidates for a request. The
+// candidates are never empty and are listed in configuration order.
+type Balancer interface {
+	Pick(r *http.Request, candidates []*Backend) *Backend
+}
+
+// NewBalancer builds the strategy configured for a pool. A nil setting
+// selects weighted random, the historical behaviour.
+func NewBalancer(lb *config.LoadBalancing, backends []*Backend) (Balancer, error) {
+	if lb == nil {
+		return randomBalancer{}, nil
+	}
+	switch lb.Strategy {
+	case config.StrategyRandom:
+		return randomBalancer{}, nil
+	case config.StrategyRoundRobin:
+		return &roundRobinBalancer{}, nil
+	case config.StrategyWeightedRoundRobin:
+		return &weightedRoundRobinBalancer{current: make(map[*Backend]int)}, nil
+	case config.StrategyLeastRequests:
+		return leastRequestsBalancer{}, nil
+	case config.StrategyP2C:
+		return p2cBalancer{}, nil
+	case config.StrategyHash:
+		if err := config.ValidateHashKey(lb.HashKey); err != nil {
+			return nil, err
+		}
+		return newHashBalancer(lb.HashKey, backends), nil
+	}
+	return nil, fmt.Errorf("unknown load-balancing strategy %q", lb.Strategy)
+}
+
+// randomBalancer picks with probability proportional to weight.
+type randomBalancer struct{}
+
+func (randomBalancer) Pick(_ *http.Request, cands []*Backend) *Backend {
+	return cands[pickWeighted(cands)]
+}
+
+// roundRobinBalancer cycles through the candidates ignoring weights.
+type roundRobinBalancer struct {
+	n atomic.Uint64
+}
+
+func (rr *roundRobinBalancer) Pick(_ *http.Request, cands []*Backend) *Backend {
+	return cands[(rr.n.Add(1)-1)%uint64(len(cands))]
+}
+
+// weightedRoundRobinBalancer is nginx's smooth weighted round-robin: every
+// backend gains its weight each turn and the leader pays back the total.
+type weightedRoundRobinBalancer struct {
+	mu      sync.Mutex
+	current map[*Backend]int
+}
+
+func (w *weightedRoundRobinBalancer) Pick(_ *http.Request, cands []*Backend) *Backend {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var best *Backend
+	total := 0
+	for _, b := range cands {
+		w.current[b] += b.Weight
+		total += b.Weight
+		if best == nil || w.current[b] > w.current[best] {
+			best = b
+		}
+	}
+	w.current[best] -= total
+	return best
+}
+
+// load is the number of in-flight requests relative to the weight.
+func load(b *Backend) float64 {
+	return float64(b.active.Load()) / float64(max(b.Weight, 1))
+}
+
+// leastRequestsBalancer picks the backend with the fewest outstanding
+// requests per unit of weight, breaking ties at random.
+type leastRequestsBalancer struct{}
+
+func (leastRequestsBalancer) Pick(_ *http.Request, cands []*Backend) *Backend {
+	var best []*Backend
+	bestLoad := 0.0
+	for _, b := range cands {
+		switch l := load(b); {
+		case best == nil || l < bestLoad:
+			best, bestLoad = []*Backend{b}, l
+		case l == bestLoad:
+			best = append(best, b)
+		}
+	}
+	return best[rand.Intn(len(best))]
+}
+
+// p2cBalancer samples two random candidates and keeps the less loaded one.
+type p2cBalancer struct{}
+
+func (p2cBalancer) Pick(_ *http.Request, cands []*Backend) *Backend {
+	if len(cands) == 1 {
+		return cands[0]
+	}
+	i := rand.Intn(len(cands))
+	j := rand.Intn(len(cands) - 1)
+	if j >= i {
+		j++
+	}
+	if load(cands[j]) < load(cands[i]) {
+		return cands[j]
+	}
+	return cands[i]
+}
+
+// hashBalancer maps a request key onto a ring of virtual nodes so that
+// adding or removing a backend only remaps the keys next to it.
+type hashBalancer struct {
+	key    func(*http.Request) string
+	hashes []uint64 // sorted
+	nodes  map[uint64]*Backend
+}
+
+// virtualNodes is the number of ring points per unit of weight.
+const virtualNodes = 100
+
+func newHashBalancer(key string, backends []*Backend) *hashBalancer {
+	hb := &hashBalancer{key: KeyFunc(key), nodes: make(map[uint64]*Backend)}
+	for _, b := range backends {
+		for i := 0; i < virtualNodes*max(b.Weight, 1); i++ {
+			h := hash64(b.Name + "#" + strconv.Itoa(i))
+			if _, taken := hb.nodes[h]; taken {
+				continue
+			}
+			hb.nodes[h] = b
+			hb.hashes = append(hb.hashes, h)
+		}
+	}
+	sort.Slice(hb.hashes, func(i, j int) bool { return hb.hashes[i] < hb.hashes[j] })
+	return hb
+}
+
+// Pick walks the ring clockwise from the key's hash to the first node whose
+// backend is a candidate, so unavailable backends only shed their own keys.
+func (hb *hashBalancer) Pick(r *http.Request, cands []*Backend) *Backend {
+	allowed := make(map[*Backend]bool, len(cands))
+	for _, b := range cands {
+		allowed[b] = true
+	}
+	h := hash64(hb.key(r))
+	start := sort.Search(len(hb.hashes), func(i int) bool { return hb.hashes[i] >= h })
+	for i := 0; i < len(hb.hashes); i++ {
+		if b := hb.nodes[hb.hashes[(start+i)%len(hb.hashes)]]; allowed[b] {
+			return b
+		}
+	}
+	return cands[0]
+}
+
+// KeyFunc returns the extractor for a validated hash key source (see
+// config.ValidateHashKey).
+func KeyFunc(key string) func(*http.Request) string {
+	kind, name, _ := strings.Cut(key, ":")
+	switch kind {
+	case "path":
+		return func(r *http.Request) string { return r.URL.Path }
+	case "header":
+		return func(r *http.Request) string { return r.Header.Get(name) }
+	case "cookie":
+		return func(r *http.Request) string {
+			if c, err := r.Cookie(name); err == nil {
+				return c.Value
+			}
+			return ""
+		}
+	default:
+		return clientIP
+	}
+}
+
+// clientIP returns the address of the direct client, without the port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// hash64 spreads s over the ring. FNV alone barely moves the high bits for
+// strings that differ only at the end (user-1, user-2; a#1, a#2), which would
+// bunch keys and virtual nodes together, so the result goes through the
+// murmur3 finalizer.
+func hash64(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
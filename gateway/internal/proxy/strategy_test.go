@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/RafaelZelak/gateway/internal/config"
+)
+
+func testBackends(weights ...int) []*Backend {
+	bs := make([]*Backend, len(weights))
+	for i, w := range weights {
+		bs[i] = &Backend{Name: string(rune('a' + i)), Weight: w}
+	}
+	return bs
+}
+
+func testUpstream(name, url string) config.Upstream {
+	return config.ServiceConfig{Targets: []config.Upstream{{Name: name, URL: url}}}.Upstreams()[0]
+}
+
+func keyRequest(key string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-User", key)
+	return r
+}
+
+func TestHashRemovingBackendOnlyRemapsItsKeys(t *testing.T) {
+	bs := testBackends(1, 1, 1, 1)
+	full := newHashBalancer("header:X-User", bs)
+	// the ring as rebuilt by a reload without "c"
+	without := newHashBalancer("header:X-User", []*Backend{bs[0], bs[1], bs[3]})
+	remaining := []*Backend{bs[0], bs[1], bs[3]}
+
+	moved := 0
+	for i := 0; i < 2000; i++ {
+		r := keyRequest("user-" + strconv.Itoa(i))
+		before := full.Pick(r, bs)
+		for name, hb := range map[string]*hashBalancer{"unavailable": full, "removed": without} {
+			after := hb.Pick(r, remaining)
+			if before != bs[2] && after != before {
+				t.Fatalf("%s c: key %d moved from %s to %s", name, i, before.Name, after.Name)
+			}
+		}
+		if before == bs[2] {
+			moved++
+		}
+	}
+	// c held about a quarter of the keys
+	if moved < 300 || moved > 700 {
+		t.Errorf("c held %d of 2000 keys, want about 500", moved)
+	}
+}
+
+func TestHashIsStableAndFollowsWeights(t *testing.T) {
+	bs := testBackends(3, 1)
+	hb := newHashBalancer("header:X-User", bs)
+	counts := map[string]int{}
+	for i := 0; i < 4000; i++ {
+		r := keyRequest("user-" + strconv.Itoa(i))
+		b := hb.Pick(r, bs)
+		if again := hb.Pick(r, bs); again != b {
+			t.Fatalf("key %d went to %s, then %s", i, b.Name, again.Name)
+		}
+		counts[b.Name]++
+	}
+	if counts["a"] < 2600 || counts["a"] > 3400 {
+		t.Errorf("weight 3 of 4 got %d of 4000 keys, want about 3000", counts["a"])
+	}
+}
+
+func TestKeyFunc(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/items/7", nil)
+	r.RemoteAddr = "10.1.2.3:5555"
+	r.Header.Set("X-Tenant", "acme")
+	r.AddCookie(&http.Cookie{Name: "sid", Value: "s1"})
+	for key, want := range map[string]string{
+		"":                "10.1.2.3",
+		"ip":              "10.1.2.3",
+		"path":            "/items/7",
+		"header:X-Tenant": "acme",
+		"cookie:sid":      "s1",
+		"cookie:missing":  "",
+	} {
+		if got := KeyFunc(key)(r); got != want {
+			t.Errorf("KeyFunc(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestWeightedRoundRobinIsSmooth(t *testing.T) {
+	bs := testBackends(5, 1, 1)
+	b, err := NewBalancer(&config.LoadBalancing{Strategy: config.StrategyWeightedRoundRobin}, bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seq []string
+	for i := 0; i < 14; i++ {
+		seq = append(seq, b.Pick(nil, bs).Name)
+	}
+	// nginx's sequence for 5:1:1, twice
+	if got, want := strings.Join(seq, ""), "aabacaaaabacaa"; got != want {
+		t.Errorf("sequence = %s, want %s", got, want)
+	}
+}
+
+func TestRoundRobinAndLeastRequests(t *testing.T) {
+	bs := testBackends(1, 1, 1)
+	rr, _ := NewBalancer(&config.LoadBalancing{Strategy: config.StrategyRoundRobin}, bs)
+	var seq []string
+	for i := 0; i < 6; i++ {
+		seq = append(seq, rr.Pick(nil, bs).Name)
+	}
+	if got := strings.Join(seq, ""); got != "abcabc" {
+		t.Errorf("round robin sequence = %s, want abcabc", got)
+	}
+
+	bs[0].active.Store(3)
+	bs[1].active.Store(1)
+	bs[2].active.Store(2)
+	lr, _ := NewBalancer(&config.LoadBalancing{Strategy: config.StrategyLeastRequests}, bs)
+	for i := 0; i < 10; i++ {
+		if b := lr.Pick(nil, bs); b != bs[1] {
+			t.Fatalf("least requests picked %s, want b", b.Name)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/RafaelZelak/gateway/pkg/httperr"
+)
+
+// DeadlineHeader tells the backend when the gateway stops waiting for it,
+// as an RFC 3339 timestamp.
+const DeadlineHeader = "X-Request-Deadline"
+
+// callerDeadlineKey marks a request context whose deadline was announced by
+// the caller rather than set by the gateway.
+type callerDeadlineKey struct{}
+
+// WithDeadline bounds every request to d, or to the earlier deadline a
+// caller already announced in DeadlineHeader, and passes the deadline on.
+func WithDeadline(next http.Handler, d time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline := time.Now().Add(d)
+		ctx := r.Context()
+		if v := r.Header.Get(DeadlineHeader); v != "" {
+			if t, err := time.Parse(time.RFC3339Nano, v); err == nil && t.Before(deadline) {
+				deadline = t
+				ctx = context.WithValue(ctx, callerDeadlineKey{}, true)
+			}
+		}
+		ctx, cancel := context.WithDeadline(ctx, deadline)
+		defer cancel()
+
+		r = r.WithContext(ctx)
+		r.Header.Set(DeadlineHeader, deadline.UTC().Format(time.RFC3339Nano))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// callerGaveUp reports whether r ended because the client went away or ran
+// out the deadline it announced itself; neither says anything about the
+// backend, and anyone can send a deadline that has already passed.
+func callerGaveUp(r *http.Request) bool {
+	err := r.Context().Err()
+	if errors.Is(err, context.Canceled) {
+		return true
+	}
+	announced, _ := r.Context().Value(callerDeadlineKey{}).(bool)
+	return announced && errors.Is(err, context.DeadlineExceeded)
+}
+
+// proxyError is the ErrorHandler of every reverse proxy: timeouts become a
+// 504, other failures a 502.
+func proxyError(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("http: proxy error: %v", err)
+	if isTimeout(err) {
+		httperr.Write(w, r, http.StatusGatewayTimeout, httperr.CodeUpstreamTimeout, "upstream timed out")
+		return
+	}
+	httperr.Write(w, r, http.StatusBadGateway, httperr.CodeBadGateway, "upstream unavailable")
+}
+
+// isTimeout reports whether err comes from a deadline or a transport timeout.
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/RafaelZelak/gateway/internal/config"
+)
+
+func TestCallerDeadlineDoesNotCountAgainstBackends(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	lb, err := BuildLoadBalancer([]config.Upstream{
+		testUpstream("a", backend.URL),
+		testUpstream("b", backend.URL+"/"),
+	}, nil, NewDefaultTransport())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lb.Close()
+	lb.EnableOutlierDetection(config.OutlierDetection{ConsecutiveErrors: 1, MaxEjectionPercent: 100})
+	lb.EnableCircuitBreakers(config.CircuitBreaker{FailureThreshold: 1})
+	h := WithDeadline(lb, time.Minute)
+
+	for i := 0; i < 4; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(DeadlineHeader, time.Now().Add(-time.Second).Format(time.RFC3339Nano))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusGatewayTimeout {
+			t.Fatalf("status = %d, want 504", rec.Code)
+		}
+	}
+	for _, s := range lb.Status() {
+		if s.Ejected || s.Circuit != CircuitClosed {
+			t.Errorf("backend %s: ejected=%v circuit=%s after caller deadlines, want it untouched", s.Name, s.Ejected, s.Circuit)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d without a caller deadline, want 200", rec.Code)
+	}
+}
@@ -0,0 +1,247 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/RafaelZelak/gateway/internal/config"
+	"github.com/RafaelZelak/gateway/pkg/httperr"
+	"github.com/gorilla/websocket"
+)
+
+// closeGrace is how long the proxy waits for the second close frame after
+// one side has closed the session.
+const closeGrace = 5 * time.Second
+
+// NewWebSocketProxyHandler proxies WebSocket connections between client and
+// backend. target may be ws:// or wss://; tlsConfig applies to the latter.
+// The backend is dialed first, so its subprotocol choice (or its refusal of
+// the handshake) is what the client gets back.
+func NewWebSocketProxyHandler(prefix, target string, cfg config.WebSocket, tlsConfig *tls.Config) http.Handler {
+	cfg = cfg.WithDefaults()
+	upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+	base := websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: 45 * time.Second,
+		TLSClientConfig:  tlsConfig,
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !websocket.IsWebSocketUpgrade(r) {
+			httperr.Write(w, r, http.StatusBadRequest, httperr.CodeBadRequest, "WebSocket upgrade required")
+			return
+		}
+
+		backendURL := target + r.URL.EscapedPath()
+		if r.URL.RawQuery != "" {
+			backendURL += "?" + r.URL.RawQuery
+		}
+		dialer := base
+		dialer.Subprotocols = websocket.Subprotocols(r)
+		backendConn, resp, err := dialer.DialContext(r.Context(), backendURL, forwardHeaders(r, cfg.ForwardHeaders))
+		if err != nil {
+			log.Printf("WebSocket backend dial error: %v", err)
+			if resp != nil {
+				// the backend answered but refused the upgrade: pass that on
+				defer resp.Body.Close()
+				for k, vs := range resp.Header {
+					w.Header()[k] = vs
+				}
+				w.WriteHeader(resp.StatusCode)
+				io.Copy(w, resp.Body)
+				return
+			}
+			httperr.Write(w, r, http.StatusBadGateway, httperr.CodeBadGateway, "upstream unavailable")
+			return
+		}
+		defer backendConn.Close()
+
+		header := http.Header{}
+		if p := backendConn.Subprotocol(); p != "" {
+			header.Set("Sec-WebSocket-Protocol", p)
+		}
+		for _, c := range resp.Header.Values("Set-Cookie") {
+			header.Add("Set-Cookie", c)
+		}
+		clientConn, err := upgrader.Upgrade(w, r, header)
+		if err != nil {
+			log.Printf("WebSocket upgrade error: %v", err)
+			return
+		}
+		defer clientConn.Close()
+
+		relay(cfg, clientConn, backendConn)
+	})
+}
+
+// forwardHeaders builds the backend handshake headers: the configured client
+// headers plus X-Forwarded-For and what the gateway itself added.
+func forwardHeaders(r *http.Request, names []string) http.Header {
+	h := http.Header{}
+	for _, name := range names {
+		if vs := r.Header.Values(name); len(vs) > 0 {
+			h[http.CanonicalHeaderKey(name)] = vs
+		}
+	}
+	for _, name := range []string{httperr.RequestIDHeader, "X-Forwarded-Prefix"} {
+		if v := r.Header.Get(name); v != "" {
+			h.Set(name, v)
+		}
+	}
+	appendForwardedFor(h, r)
+	return h
+}
+
+// appendForwardedFor sets X-Forwarded-For in h to the chain r arrived with
+// plus the client's address, as httputil.ReverseProxy does.
+func appendForwardedFor(h http.Header, r *http.Request) {
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if prior := r.Header.Values("X-Forwarded-For"); len(prior) > 0 {
+			ip = strings.Join(prior, ", ") + ", " + ip
+		}
+		h.Set("X-Forwarded-For", ip)
+	}
+}
+
+// session is one proxied WebSocket connection: the client and backend legs
+// and the limits both of them are held to.
+type session struct {
+	cfg      config.WebSocket
+	client   leg
+	backend  leg
+	activity atomic.Int64 // time of the last data message, in unix nanoseconds
+}
+
+// leg is one side of a session.
+type leg struct {
+	conn *websocket.Conn
+	gone int // close code sent to the other leg when this one is lost
+}
+
+// relay copies messages both ways. When one side closes, its close frame is
+// passed on with the same code and reason, and the other side's reply gets
+// closeGrace to travel back before both connections are dropped. Both legs
+// are pinged every PingInterval and dropped when no pong arrives within
+// PongTimeout.
+func relay(cfg config.WebSocket, client, backend *websocket.Conn) {
+	s := &session{
+		cfg:     cfg,
+		client:  leg{conn: client, gone: websocket.CloseGoingAway},
+		backend: leg{conn: backend, gone: websocket.CloseInternalServerErr},
+	}
+	for _, c := range []*websocket.Conn{client, backend} {
+		c.SetReadLimit(cfg.MaxMessageSize)
+		// don't answer close frames ourselves; the peer behind the proxy does
+		c.SetCloseHandler(func(int, string) error { return nil })
+		c.SetPongHandler(func(string) error { return s.extend(c) })
+		s.extend(c)
+	}
+	s.touch()
+
+	errc := make(chan error, 2)
+	go func() { errc <- s.pipe(&s.backend, &s.client) }()
+	go func() { errc <- s.pipe(&s.client, &s.backend) }()
+
+	ping := time.NewTicker(cfg.PingInterval)
+	defer ping.Stop()
+	var idle <-chan time.Time
+	var idleTimer *time.Timer
+	if cfg.IdleTimeout > 0 {
+		idleTimer = time.NewTimer(cfg.IdleTimeout)
+		defer idleTimer.Stop()
+		idle = idleTimer.C
+	}
+
+	for {
+		select {
+		case <-errc:
+			wait(errc, 1)
+			return
+		case <-ping.C:
+			client.WriteControl(websocket.PingMessage, nil, s.writeDeadline())
+			backend.WriteControl(websocket.PingMessage, nil, s.writeDeadline())
+		case <-idle:
+			if left := cfg.IdleTimeout - time.Since(time.Unix(0, s.activity.Load())); left > 0 {
+				idleTimer.Reset(left)
+				continue
+			}
+			msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "idle timeout")
+			client.WriteControl(websocket.CloseMessage, msg, s.writeDeadline())
+			backend.WriteControl(websocket.CloseMessage, msg, s.writeDeadline())
+			wait(errc, 2)
+			return
+		}
+	}
+}
+
+// wait collects n more results from errc, giving up after closeGrace.
+func wait(errc <-chan error, n int) {
+	timeout := time.After(closeGrace)
+	for ; n > 0; n-- {
+		select {
+		case <-errc:
+		case <-timeout:
+			return
+		}
+	}
+}
+
+// extend pushes back the read deadline of c after a message or pong.
+func (s *session) extend(c *websocket.Conn) error {
+	return c.SetReadDeadline(time.Now().Add(s.cfg.PingInterval + s.cfg.PongTimeout))
+}
+
+// touch records data activity for the idle timeout.
+func (s *session) touch() {
+	s.activity.Store(time.Now().UnixNano())
+}
+
+func (s *session) writeDeadline() time.Time {
+	return time.Now().Add(s.cfg.WriteTimeout)
+}
+
+// pipe copies messages from src to dst until either fails. A close frame from
+// src is forwarded as is; when src is lost without one, dst is closed with
+// src.gone instead, and the other way round when dst cannot be written.
+func (s *session) pipe(dst, src *leg) error {
+	for {
+		mt, msg, err := src.conn.ReadMessage()
+		if err != nil {
+			code, text := src.gone, ""
+			var ce *websocket.CloseError
+			var ne net.Error
+			switch {
+			case errors.As(err, &ce) && ce.Code != websocket.CloseAbnormalClosure && ce.Code != websocket.CloseTLSHandshake:
+				// 1006 and 1015 are local-only codes, never valid on the wire
+				code, text = ce.Code, ce.Text
+			case errors.Is(err, websocket.ErrReadLimit):
+				// src has already been closed with 1009
+				text = "message too big"
+			case errors.As(err, &ne) && ne.Timeout():
+				text = "pong timeout"
+				src.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, text), s.writeDeadline())
+			}
+			dst.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), s.writeDeadline())
+			return err
+		}
+		s.extend(src.conn)
+		s.touch()
+
+		dst.conn.SetWriteDeadline(s.writeDeadline())
+		if err := dst.conn.WriteMessage(mt, msg); err != nil {
+			text := ""
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				text = "write timeout"
+			}
+			src.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(dst.gone, text), s.writeDeadline())
+			return err
+		}
+	}
+}
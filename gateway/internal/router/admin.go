@@ -0,0 +1,160 @@
+package router
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/RafaelZelak/gateway/internal/config"
+	"github.com/RafaelZelak/gateway/internal/proxy"
+	"github.com/RafaelZelak/gateway/pkg/httperr"
+)
+
+// poolInfo ties a load balancer to the route it serves.
+type poolInfo struct {
+	id    string // serviceID, to hand the pool's state to the next router
+	host  string
+	route string
+	group string // split group, if any
+	lb    *proxy.LoadBalancer
+}
+
+// cacheInfo ties a response cache to the route it serves.
+type cacheInfo struct {
+	host  string
+	route string
+	cache *proxy.Cache
+}
+
+// upstreamStatus is one entry of the admin upstreams listing.
+type upstreamStatus struct {
+	Host     string                `json:"host,omitempty"`
+	Route    string                `json:"route"`
+	Group    string                `json:"group,omitempty"`
+	Backends []proxy.BackendStatus `json:"backends"`
+}
+
+// mountAdmin registers the admin endpoints under the admin route on every
+// host; config.AdminConfig.Patterns lists the same paths for validation.
+func (rt *Router) mountAdmin(admin config.AdminConfig) error {
+	prefix := strings.TrimRight(admin.Route, "/")
+	return errors.Join(
+		rt.any.handle(prefix+"/upstreams", adminAuth(admin.Token, rt.serveUpstreams)),
+		rt.any.handle(prefix+"/metrics", adminAuth(admin.Token, rt.serveMetrics)),
+		rt.any.handle(prefix+"/cache/purge", adminAuth(admin.Token, rt.servePurge)),
+		rt.any.handle(prefix+"/split", adminAuth(admin.Token, rt.serveSplit)),
+	)
+}
+
+// adminAuth guards an admin endpoint. With a token, requests must carry it
+// as "Authorization: Bearer <token>"; without one, only GET and HEAD pass,
+// so nothing can change the gateway's state anonymously.
+func adminAuth(token string, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				httperr.Write(w, r, http.StatusForbidden, httperr.CodeForbidden, "admin changes require admin.token")
+				return
+			}
+			next(w, r)
+			return
+		}
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gateway admin"`)
+			httperr.Write(w, r, http.StatusUnauthorized, httperr.CodeUnauthorized, "admin token required")
+			return
+		}
+		next(w, r)
+	})
+}
+
+// serveUpstreams lists the current state of every load-balanced backend.
+func (rt *Router) serveUpstreams(w http.ResponseWriter, r *http.Request) {
+	out := make([]upstreamStatus, 0, len(rt.pools))
+	for _, p := range rt.pools {
+		out = append(out, upstreamStatus{Host: p.host, Route: p.route, Group: p.group, Backends: p.lb.Status()})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// servePurge drops cached responses. The optional route and host query
+// parameters select the caches, prefix the request URIs to remove.
+func (rt *Router) servePurge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "POST, DELETE")
+		httperr.Write(w, r, http.StatusMethodNotAllowed, httperr.CodeMethodNotAllowed, "use POST or DELETE")
+		return
+	}
+	q := r.URL.Query()
+	route, host, prefix := q.Get("route"), q.Get("host"), q.Get("prefix")
+	purged := 0
+	for _, c := range rt.caches {
+		if (route == "" || c.route == route) && (host == "" || strings.EqualFold(c.host, host)) {
+			purged += c.cache.Purge(prefix)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"purged": purged})
+}
+
+// splitStatus is one entry of the admin split listing.
+type splitStatus struct {
+	Host   string         `json:"host,omitempty"`
+	Route  string         `json:"route"`
+	Groups map[string]int `json:"groups"`
+}
+
+// serveSplit lists the weights of every split service on GET and changes
+// them on POST, for the route (and host) given in the query, from a JSON
+// object of group name to weight.
+func (rt *Router) serveSplit(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		out := make([]splitStatus, 0, len(rt.splits))
+		for _, s := range rt.splits {
+			groups := make(map[string]int, len(s.names))
+			for i, wt := range *s.weights.Load() {
+				groups[s.names[i]] = wt
+			}
+			out = append(out, splitStatus{Host: s.host, Route: s.route, Groups: groups})
+		}
+		writeJSON(w, http.StatusOK, out)
+
+	case http.MethodPost:
+		route, host := r.URL.Query().Get("route"), r.URL.Query().Get("host")
+		var target *splitter
+		for _, s := range rt.splits {
+			if s.route == route && strings.EqualFold(s.host, host) {
+				target = s
+			}
+		}
+		if target == nil {
+			httperr.Write(w, r, http.StatusNotFound, httperr.CodeNotFound, "no split service for this route and host")
+			return
+		}
+		var changes map[string]int
+		if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+			httperr.Write(w, r, http.StatusBadRequest, httperr.CodeBadRequest, "body must be a JSON object of group weights")
+			return
+		}
+		if err := target.setWeights(changes); err != nil {
+			httperr.Write(w, r, http.StatusBadRequest, httperr.CodeBadRequest, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		httperr.Write(w, r, http.StatusMethodNotAllowed, httperr.CodeMethodNotAllowed, "use GET or POST")
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
@@ -0,0 +1,189 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/RafaelZelak/gateway/pkg/httperr"
+)
+
+// vhost holds the routes of one virtual host.
+type vhost struct {
+	mux      *http.ServeMux
+	def      http.Handler // serves paths no route of this host matches
+	patterns map[string]bool
+	groups   map[string]*matchGroup
+}
+
+func newVhost() *vhost {
+	return &vhost{
+		mux:      http.NewServeMux(),
+		patterns: make(map[string]bool),
+		groups:   make(map[string]*matchGroup),
+	}
+}
+
+// handle registers pattern once; services sharing a route (login pages,
+// static assets) would otherwise make ServeMux panic. A pattern ServeMux
+// rejects is returned as an error, so a bad config fails the reload instead
+// of crashing the gateway.
+func (vh *vhost) handle(pattern string, h http.Handler) (err error) {
+	if vh.patterns[pattern] {
+		return nil
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("route %s: %v", pattern, r)
+		}
+	}()
+	vh.mux.Handle(pattern, h)
+	vh.patterns[pattern] = true
+	return nil
+}
+
+// group returns the match group serving route, registering it on first use.
+func (vh *vhost) group(route string) (*matchGroup, error) {
+	g, ok := vh.groups[route]
+	if !ok {
+		g = &matchGroup{}
+		// register main handler (with and without trailing slash)
+		if err := errors.Join(vh.handle(route, g), vh.handle(route+"/", g)); err != nil {
+			return nil, err
+		}
+		vh.groups[route] = g
+	}
+	return g, nil
+}
+
+// wildcardHost is a *.domain entry; suffix keeps the leading dot.
+type wildcardHost struct {
+	suffix string
+	vh     *vhost
+}
+
+// Router dispatches requests by host first and path second. Services without
+// a host are shared by every host and answer when no host-specific route does.
+type Router struct {
+	exact     map[string]*vhost
+	wildcards []wildcardHost
+	any       *vhost
+
+	pools   []poolInfo      // load balancers, for the admin endpoints
+	caches  []cacheInfo     // response caches, for the admin purge endpoint
+	splits  []*splitter     // traffic splits, for the admin weights endpoint
+	steps   map[string]step // stateful middleware, by serviceID + step name
+	closers []io.Closer
+}
+
+// Close stops the background work (health checks) of every route, drops
+// the idle upstream connections of its transports and closes the log files
+// no other router writes to. Requests already running on this router are not
+// interrupted, but lose their access log line if their file was closed.
+func (rt *Router) Close() error {
+	for _, c := range rt.closers {
+		c.Close()
+	}
+	return nil
+}
+
+// transport registers t so Close releases its idle connections.
+func (rt *Router) transport(t http.RoundTripper) http.RoundTripper {
+	rt.closers = append(rt.closers, transportCloser{t})
+	return t
+}
+
+// transportCloser adapts a transport to io.Closer.
+type transportCloser struct{ t http.RoundTripper }
+
+func (c transportCloser) Close() error {
+	if t, ok := c.t.(interface{ CloseIdleConnections() }); ok {
+		t.CloseIdleConnections()
+	}
+	return nil
+}
+
+func newHostRouter() *Router {
+	return &Router{
+		exact: make(map[string]*vhost),
+		steps: make(map[string]step),
+		any:   newVhost(),
+	}
+}
+
+// vhost returns the routes for host, creating them on first use.
+func (rt *Router) vhost(host string) *vhost {
+	host = strings.ToLower(host)
+	if host == "" {
+		return rt.any
+	}
+	if suffix, ok := strings.CutPrefix(host, "*"); ok {
+		for _, w := range rt.wildcards {
+			if w.suffix == suffix {
+				return w.vh
+			}
+		}
+		vh := newVhost()
+		rt.wildcards = append(rt.wildcards, wildcardHost{suffix: suffix, vh: vh})
+		// most specific wildcard first
+		sort.SliceStable(rt.wildcards, func(i, j int) bool {
+			return len(rt.wildcards[i].suffix) > len(rt.wildcards[j].suffix)
+		})
+		return vh
+	}
+	vh, ok := rt.exact[host]
+	if !ok {
+		vh = newVhost()
+		rt.exact[host] = vh
+	}
+	return vh
+}
+
+// match returns the virtual hosts that apply to the request host, most
+// specific first, ending with the host-independent routes.
+func (rt *Router) match(host string) []*vhost {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	var out []*vhost
+	if vh, ok := rt.exact[host]; ok {
+		out = append(out, vh)
+	}
+	for _, w := range rt.wildcards {
+		if strings.HasSuffix(host, w.suffix) {
+			out = append(out, w.vh)
+			break
+		}
+	}
+	return append(out, rt.any)
+}
+
+// ServeHTTP routes the request or answers with a 404 like WrapMux.
+// Explicit routes of every matching host win over any default route.
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	hosts := rt.match(r.Host)
+	for _, vh := range hosts {
+		if h, pattern := vh.mux.Handler(r); pattern != "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+	}
+	for _, vh := range hosts {
+		if vh.def != nil {
+			vh.def.ServeHTTP(w, r)
+			return
+		}
+	}
+	writeNotFound(w, r)
+}
+
+// writeNotFound sends the same 404 as WrapMux.
+func writeNotFound(w http.ResponseWriter, r *http.Request) {
+	httperr.Write(w, r, http.StatusNotFound, httperr.CodeNotFound, "resource not found")
+}
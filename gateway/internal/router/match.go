@@ -0,0 +1,128 @@
+package router
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+
+	"github.com/RafaelZelak/gateway/internal/config"
+)
+
+// valueMatcher is a compiled config.StringMatch.
+type valueMatcher struct {
+	exact string
+	re    *regexp.Regexp
+}
+
+// matches reports whether any of the values sent under a name matches; a
+// header, query parameter or cookie may be repeated.
+func (m valueMatcher) matches(values []string) bool {
+	for _, v := range values {
+		if m.re != nil && m.re.MatchString(v) || m.re == nil && v == m.exact {
+			return true
+		}
+	}
+	return false
+}
+
+// predicate is a compiled config.MatchConfig.
+type predicate struct {
+	methods map[string]bool
+	headers map[string]valueMatcher
+	query   map[string]valueMatcher
+	cookies map[string]valueMatcher
+}
+
+func compileValues(values map[string]config.StringMatch, canon func(string) string) map[string]valueMatcher {
+	out := make(map[string]valueMatcher, len(values))
+	for k, v := range values {
+		vm := valueMatcher{exact: v.Exact}
+		if v.Regex != "" {
+			// already validated by config.Validate
+			vm.re = regexp.MustCompile(v.Regex)
+		}
+		if canon != nil {
+			k = canon(k)
+		}
+		out[k] = vm
+	}
+	return out
+}
+
+func compilePredicate(m *config.MatchConfig) *predicate {
+	if m == nil {
+		return &predicate{}
+	}
+	p := &predicate{
+		headers: compileValues(m.Headers, http.CanonicalHeaderKey),
+		query:   compileValues(m.Query, nil),
+		cookies: compileValues(m.Cookies, nil),
+	}
+	if len(m.Methods) > 0 {
+		p.methods = make(map[string]bool, len(m.Methods))
+		for _, method := range m.Methods {
+			p.methods[method] = true
+		}
+	}
+	return p
+}
+
+// matches reports whether r satisfies every predicate.
+func (p *predicate) matches(r *http.Request) bool {
+	if p.methods != nil && !p.methods[r.Method] {
+		return false
+	}
+	for name, m := range p.headers {
+		if !m.matches(r.Header[name]) {
+			return false
+		}
+	}
+	if len(p.query) > 0 {
+		q := r.URL.Query()
+		for name, m := range p.query {
+			if !m.matches(q[name]) {
+				return false
+			}
+		}
+	}
+	for name, m := range p.cookies {
+		var values []string
+		for _, c := range r.CookiesNamed(name) {
+			values = append(values, c.Value)
+		}
+		if !m.matches(values) {
+			return false
+		}
+	}
+	return true
+}
+
+// candidate is one service competing for a shared route.
+type candidate struct {
+	priority int
+	pred     *predicate
+	handler  http.Handler
+}
+
+// matchGroup serves a host + route shared by one or more services, picking
+// the highest-priority service whose predicates match the request.
+type matchGroup struct {
+	candidates []candidate
+}
+
+func (g *matchGroup) add(priority int, m *config.MatchConfig, h http.Handler) {
+	g.candidates = append(g.candidates, candidate{priority: priority, pred: compilePredicate(m), handler: h})
+	sort.SliceStable(g.candidates, func(i, j int) bool {
+		return g.candidates[i].priority > g.candidates[j].priority
+	})
+}
+
+func (g *matchGroup) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, c := range g.candidates {
+		if c.pred.matches(r) {
+			c.handler.ServeHTTP(w, r)
+			return
+		}
+	}
+	writeNotFound(w, r)
+}
@@ -0,0 +1,83 @@
+package router
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/RafaelZelak/gateway/internal/config"
+)
+
+func TestPredicateChecksEveryValue(t *testing.T) {
+	p := compilePredicate(&config.MatchConfig{
+		Headers: map[string]config.StringMatch{"x-api-version": {Exact: "2"}},
+		Query:   map[string]config.StringMatch{"tag": {Regex: "^beta$"}},
+		Cookies: map[string]config.StringMatch{"plan": {Exact: "pro"}},
+	})
+	for _, tc := range []struct {
+		name  string
+		setup func(r *http.Request)
+		want  bool
+	}{
+		{"first values", func(r *http.Request) {
+			r.Header.Add("X-Api-Version", "2")
+			r.URL.RawQuery = "tag=beta"
+			r.Header.Add("Cookie", "plan=pro")
+		}, true},
+		{"later values", func(r *http.Request) {
+			r.Header.Add("X-Api-Version", "1")
+			r.Header.Add("X-Api-Version", "2")
+			r.URL.RawQuery = "tag=stable&tag=beta"
+			r.Header.Add("Cookie", "plan=free; plan=pro")
+		}, true},
+		{"no matching header value", func(r *http.Request) {
+			r.Header.Add("X-Api-Version", "1")
+			r.Header.Add("X-Api-Version", "3")
+			r.URL.RawQuery = "tag=beta"
+			r.Header.Add("Cookie", "plan=pro")
+		}, false},
+		{"missing cookie", func(r *http.Request) {
+			r.Header.Add("X-Api-Version", "2")
+			r.URL.RawQuery = "tag=beta"
+		}, false},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		tc.setup(r)
+		if got := p.matches(r); got != tc.want {
+			t.Errorf("%s: matches = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestMatchGroupWithoutMatchIsNotFound(t *testing.T) {
+	g := &matchGroup{}
+	g.add(0, &config.MatchConfig{Methods: []string{http.MethodPost}}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "write")
+	}))
+	g.add(10, &config.MatchConfig{Methods: []string{http.MethodPost, http.MethodGet}, Headers: map[string]config.StringMatch{"X-Beta": {Exact: "1"}}},
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "beta")
+		}))
+
+	for _, tc := range []struct {
+		method, beta string
+		code         int
+		body         string
+	}{
+		{http.MethodPost, "1", http.StatusOK, "beta"},
+		{http.MethodPost, "", http.StatusOK, "write"},
+		{http.MethodGet, "1", http.StatusOK, "beta"},
+		{http.MethodGet, "", http.StatusNotFound, ""},
+	} {
+		r := httptest.NewRequest(tc.method, "/", nil)
+		if tc.beta != "" {
+			r.Header.Set("X-Beta", tc.beta)
+		}
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, r)
+		if rec.Code != tc.code || tc.body != "" && rec.Body.String() != tc.body {
+			t.Errorf("%s beta=%q: got %d %q, want %d %q", tc.method, tc.beta, rec.Code, rec.Body.String(), tc.code, tc.body)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/RafaelZelak/gateway/internal/proxy"
+)
+
+// backendMetric is one metric family derived from the backend status.
+type backendMetric struct {
+	name, kind, help string
+	value            func(proxy.BackendStatus) float64
+}
+
+var backendMetrics = []backendMetric{
+	{"gateway_backend_healthy", "gauge", "Whether the backend passes its health checks.", func(s proxy.BackendStatus) float64 { return boolValue(s.Healthy) }},
+	{"gateway_backend_ejected", "gauge", "Whether the backend is ejected by outlier detection.", func(s proxy.BackendStatus) float64 { return boolValue(s.Ejected) }},
+	{"gateway_backend_active_requests", "gauge", "Requests in flight to the backend.", func(s proxy.BackendStatus) float64 { return float64(s.Active) }},
+	{"gateway_circuit_opens_total", "counter", "Times the backend's circuit breaker opened.", func(s proxy.BackendStatus) float64 { return float64(s.Opens) }},
+}
+
+var circuitStates = []string{proxy.CircuitClosed, proxy.CircuitOpen, proxy.CircuitHalfOpen}
+
+// serveMetrics exposes the state of every load-balanced backend in the
+// Prometheus text format.
+func (rt *Router) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	type sample struct {
+		labels string
+		status proxy.BackendStatus
+	}
+	var samples []sample
+	for _, p := range rt.pools {
+		for _, s := range p.lb.Status() {
+			labels := fmt.Sprintf(`host="%s",route="%s",group="%s",backend="%s"`, labelValue(p.host), labelValue(p.route), labelValue(p.group), labelValue(s.Name))
+			samples = append(samples, sample{labels, s})
+		}
+	}
+
+	var b strings.Builder
+	for _, m := range backendMetrics {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		for _, s := range samples {
+			fmt.Fprintf(&b, "%s{%s} %g\n", m.name, s.labels, m.value(s.status))
+		}
+	}
+	b.WriteString("# HELP gateway_circuit_state Current circuit breaker state of the backend (1 for the active state).\n# TYPE gateway_circuit_state gauge\n")
+	for _, s := range samples {
+		if s.status.Circuit == "" {
+			continue
+		}
+		for _, state := range circuitStates {
+			fmt.Fprintf(&b, "gateway_circuit_state{%s,state=\"%s\"} %g\n", s.labels, state, boolValue(s.status.Circuit == state))
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
+
+func boolValue(v bool) float64 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// labelValue escapes a Prometheus label value.
+func labelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
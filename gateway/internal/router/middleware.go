@@ -0,0 +1,58 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/RafaelZelak/gateway/internal/config"
+	"github.com/RafaelZelak/gateway/pkg/middleware"
+)
+
+// step is one named middleware in a route's chain. Stateful steps keep
+// per-client counters that survive reloads while the name (which includes
+// the settings) stays the same.
+type step struct {
+	name     string
+	wrap     func(http.Handler) http.Handler
+	stateful bool
+}
+
+// middlewareChain turns the effective middleware settings into an ordered
+// chain, outermost first: cors, rateLimit, connLimit, queue.
+func middlewareChain(mw config.MiddlewareConfig) []step {
+	var chain []step
+	if c := mw.CORS; c != nil {
+		chain = append(chain, step{name: "cors", wrap: middleware.CORS(c.Origins, c.Methods, c.Headers)})
+	}
+	if rl := mw.RateLimit; rl != nil && rl.RPS > 0 {
+		chain = append(chain, step{
+			fmt.Sprintf("rateLimit(%grps,burst=%d)", rl.RPS, rl.Burst),
+			middleware.RateLimit(rl.RPS, rl.Burst),
+			true,
+		})
+	}
+	if mw.ConnLimit != nil && *mw.ConnLimit > 0 {
+		chain = append(chain, step{fmt.Sprintf("connLimit(%d)", *mw.ConnLimit), middleware.ConnLimit(*mw.ConnLimit), true})
+	}
+	if mw.Queue != nil && *mw.Queue > 0 {
+		chain = append(chain, step{fmt.Sprintf("queue(%d)", *mw.Queue), middleware.QueueLimit(*mw.Queue), true})
+	}
+	return chain
+}
+
+// applyChain wraps h so that the first step of chain runs first.
+func applyChain(h http.Handler, chain []step) http.Handler {
+	for i := len(chain) - 1; i >= 0; i-- {
+		h = chain[i].wrap(h)
+	}
+	return h
+}
+
+// chainNames lists the steps of chain in execution order.
+func chainNames(chain []step) []string {
+	names := make([]string, len(chain))
+	for i, s := range chain {
+		names[i] = s.name
+	}
+	return names
+}
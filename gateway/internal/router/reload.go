@@ -0,0 +1,107 @@
+package router
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/RafaelZelak/gateway/internal/config"
+)
+
+// Reloader serves the current router and swaps in a freshly built one whenever
+// the configuration (a file, directory or glob) changes. Requests (and
+// WebSocket sessions) that already started keep running on the router that
+// accepted them.
+type Reloader struct {
+	path    string
+	current atomic.Pointer[Router]
+
+	mu    sync.Mutex
+	stamp string
+}
+
+// NewReloader loads the configuration at path and builds the initial router.
+func NewReloader(path string) (*Reloader, error) {
+	rl := &Reloader{path: path}
+	if err := rl.Reload(); err != nil {
+		return nil, err
+	}
+	return rl, nil
+}
+
+// Reload validates the configuration sources and, if it is valid, atomically
+// replaces the active router. On error the previous router stays in place.
+func (rl *Reloader) Reload() error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.stamp = sourceStamp(rl.path)
+
+	cfg, err := config.LoadConfig(rl.path)
+	if err != nil {
+		return err
+	}
+	rt, err := NewRouter(cfg)
+	if err != nil {
+		return err
+	}
+	if old := rl.current.Swap(rt); old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+// sourceStamp summarizes the names and modification times of every config
+// source, so adding, removing or editing a file changes the result.
+func sourceStamp(path string) string {
+	files, err := config.Sources(path)
+	if err != nil {
+		return ""
+	}
+	var b strings.Builder
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&b, "%s@%d;", f, info.ModTime().UnixNano())
+	}
+	return b.String()
+}
+
+// Watch polls the configuration sources every interval and reloads them when
+// a file is added, removed or modified. It returns when stop is closed.
+func (rl *Reloader) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			stamp := sourceStamp(rl.path)
+			rl.mu.Lock()
+			changed := stamp != "" && stamp != rl.stamp
+			rl.mu.Unlock()
+			if !changed {
+				continue
+			}
+			if err := rl.Reload(); err != nil {
+				log.Printf("[RELOAD] Keeping previous config, %s is invalid: %v", rl.path, err)
+				continue
+			}
+			log.Printf("[RELOAD] Applied new config from %s", rl.path)
+		}
+	}
+}
+
+// ServeHTTP dispatches the request to the router active at the time it arrives.
+func (rl *Reloader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rl.current.Load().ServeHTTP(w, r)
+}
@@ -1,38 +1,123 @@
 package router
 
 import (
+	"errors"
+	htmltemplate "html/template"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/RafaelZelak/gateway/internal/auth"
 	"github.com/RafaelZelak/gateway/internal/config"
 	"github.com/RafaelZelak/gateway/internal/proxy"
 	"github.com/RafaelZelak/gateway/internal/template"
+	"github.com/RafaelZelak/gateway/pkg/httperr"
 	"github.com/RafaelZelak/gateway/pkg/middleware"
 )
 
-// NewRouter mounts all routes (REST, templates, WebSocket) as defined in config.
-func NewRouter(cfg *config.Config) (*http.ServeMux, error) {
-	mux := http.NewServeMux()
-	restTransport := proxy.NewDefaultTransport()
+var (
+	logFilesMutex = &sync.Mutex{}
+	logFiles      = make(map[string]*logFile)
+)
+
+// logFile is a route log shared by every router that writes to it.
+type logFile struct {
+	*os.File
+	refs int
+}
+
+// openLog returns the shared handle for a route log file, creating its
+// directory on first use. Handles are reused across config reloads and
+// closed along with the last router using them.
+func (rt *Router) openLog(path string) (*os.File, error) {
+	logFilesMutex.Lock()
+	defer logFilesMutex.Unlock()
+
+	lf, ok := logFiles[path]
+	if !ok {
+		// ensure log directory exists
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, err
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o666)
+		if err != nil {
+			return nil, err
+		}
+		lf = &logFile{File: f}
+		logFiles[path] = lf
+	}
+	lf.refs++
+	rt.closers = append(rt.closers, logCloser(path))
+	return lf.File, nil
+}
+
+// logCloser releases one router's reference to a log file.
+type logCloser string
+
+func (path logCloser) Close() error {
+	logFilesMutex.Lock()
+	defer logFilesMutex.Unlock()
+
+	lf := logFiles[string(path)]
+	if lf.refs--; lf.refs > 0 {
+		return nil
+	}
+	delete(logFiles, string(path))
+	return lf.Close()
+}
+
+// NewRouter mounts all routes (REST, templates, WebSocket) as defined in config,
+// grouped by virtual host.
+func NewRouter(cfg *config.Config) (_ *Router, err error) {
+	rt := newHostRouter()
+	restTransport := rt.transport(proxy.NewDefaultTransport())
+	// one budget for every route, so retries cannot amplify an outage
+	retryBudget := proxy.NewRetryBudget(cfg.RetryBudget)
+	// stop health checks already started if a later route fails
+	defer func() {
+		if err != nil {
+			rt.Close()
+		}
+	}()
 
 	for _, svc := range cfg.Services {
 		var handler http.Handler
-		isWS := strings.HasPrefix(strings.TrimSpace(svc.Target), "ws://")
+		vh := rt.vhost(svc.Host)
+		kind := handlerKind(svc)
+		tlsConfig, err := svc.TLS.ClientConfig()
+		if err != nil {
+			return nil, err
+		}
+		transport, probeTransport := restTransport, restTransport
+		if svc.Timeouts != nil || tlsConfig != nil {
+			var timeouts config.Timeouts
+			if svc.Timeouts != nil {
+				timeouts = *svc.Timeouts
+			}
+			transport = rt.transport(proxy.NewTransport(timeouts, tlsConfig))
+		}
+		if tlsConfig != nil {
+			probeTransport = rt.transport(proxy.NewTransport(config.Timeouts{}, tlsConfig))
+		}
 
-		if svc.TemplateDir != "" {
+		switch kind {
+		case KindTemplate:
 			route := strings.TrimRight(svc.Route, "/")
 
 			// serve CSS static files
 			stylesPath := filepath.Join(svc.TemplateDir, "styles")
-			mux.Handle(route+"/styles/", http.StripPrefix(route+"/styles/", http.FileServer(http.Dir(stylesPath))))
+			if err := vh.handle(route+"/styles/", http.StripPrefix(route+"/styles/", http.FileServer(http.Dir(stylesPath)))); err != nil {
+				return nil, err
+			}
 
 			// serve JS static files
 			scriptsPath := filepath.Join(svc.TemplateDir, "scripts")
-			mux.Handle(route+"/scripts/", http.StripPrefix(route+"/scripts/", http.FileServer(http.Dir(scriptsPath))))
+			if err := vh.handle(route+"/scripts/", http.StripPrefix(route+"/scripts/", http.FileServer(http.Dir(scriptsPath)))); err != nil {
+				return nil, err
+			}
 
 			// template handler
 			tmplHandler, err := template.NewTemplateHandler(svc.TemplateDir, svc.Route, svc.TemplateRoutes)
@@ -41,56 +126,179 @@ func NewRouter(cfg *config.Config) (*http.ServeMux, error) {
 			}
 			handler = tmplHandler
 
-		} else {
-			targets := strings.Split(svc.Target, ",")
-			first := strings.TrimSpace(targets[0])
+		case KindWebSocket:
+			var wsConfig config.WebSocket
+			if svc.WebSocket != nil {
+				wsConfig = *svc.WebSocket
+			}
+			handler = proxy.NewWebSocketProxyHandler(svc.Route, svc.Upstreams()[0].URL, wsConfig, tlsConfig)
 
-			if isWS {
-				handler = proxy.NewWebSocketProxyHandler(svc.Route, first)
-			} else if len(targets) > 1 {
-				handler = proxy.BuildLoadBalancer(targets, restTransport)
-			} else {
-				p, err := proxy.BuildReverseProxy(first, restTransport)
+		case KindLoadBalancer:
+			lb, err := rt.buildPool(svc, "", svc.Upstreams(), transport, probeTransport, retryBudget)
+			if err != nil {
+				return nil, err
+			}
+			handler = lb
+
+		case KindSplit:
+			groups := make([]http.Handler, len(svc.Split.Groups))
+			for i, g := range svc.Split.Groups {
+				lb, err := rt.buildPool(svc, g.Name, g.Upstreams(), transport, probeTransport, retryBudget)
 				if err != nil {
 					return nil, err
 				}
-				handler = p
+				groups[i] = lb
+			}
+			sp := newSplitter(svc, groups)
+			rt.splits = append(rt.splits, sp)
+			handler = sp
+
+		default:
+			p, err := proxy.BuildReverseProxy(svc.Upstreams()[0].URL, transport)
+			if err != nil {
+				return nil, err
 			}
+			handler = p
 		}
 
+		// bound the whole upstream exchange and tell the backend the deadline
+		if svc.Timeouts != nil && svc.Timeouts.Request > 0 {
+			handler = proxy.WithDeadline(handler, svc.Timeouts.Request)
+		}
+
+		// shadow a sample of the traffic, with the path the backend sees
+		if svc.Mirror != nil {
+			mirrorLog, err := rt.openLog(svc.MirrorLog())
+			if err != nil {
+				return nil, err
+			}
+			mirror, err := proxy.NewMirror(*svc.Mirror, log.New(mirrorLog, "", 0), handler)
+			if err != nil {
+				return nil, err
+			}
+			rt.closers = append(rt.closers, mirror)
+			handler = mirror
+		}
+
+		// rewrite the path before it reaches the HTTP or WebSocket proxy
+		if kind != KindTemplate {
+			pr, err := proxy.NewPathRewriter(svc)
+			if err != nil {
+				return nil, err
+			}
+			if pr != nil {
+				handler = pr.Wrap(handler)
+			}
+		}
+
+		// cache misses of the same URL are merged into one upstream call
+		if svc.Coalesce != nil {
+			handler = proxy.NewCoalescer(*svc.Coalesce, handler)
+		}
+
+		// the cache sees the client's URL, so purges match what clients request
+		if svc.Cache != nil {
+			cache := proxy.NewCache(*svc.Cache, handler)
+			rt.caches = append(rt.caches, cacheInfo{host: svc.Host, route: svc.Route, cache: cache})
+			handler = cache
+		}
+
+		// browser routes may render gateway errors with their own page
+		withPage := func(h http.Handler) http.Handler { return h }
+		if svc.ErrorPage != "" {
+			page, err := htmltemplate.ParseFiles(svc.ErrorPage)
+			if err != nil {
+				return nil, err
+			}
+			withPage = func(h http.Handler) http.Handler { return httperr.WithPage(h, page) }
+		}
+
+		// the declarative middleware chain (cors, limits, queue); login and
+		// logout share it, so their limits count against the route's
+		chain := rt.keepChain(svc, middlewareChain(cfg.MiddlewareFor(svc)))
+
 		if svc.Login {
-			// register login endpoint
-			mux.Handle(svc.Route+"/login", auth.LoginHandler(svc.Route, svc.SessionDuration))
-			mux.Handle(svc.Route+"/login/", auth.LoginHandler(svc.Route, svc.SessionDuration))
-			// register logout endpoint
-			mux.Handle(svc.Route+"/logout", auth.LogoutHandler(svc.Route))
-			mux.Handle(svc.Route+"/logout/", auth.LogoutHandler(svc.Route))
+			// register login and logout endpoints
+			login := withPage(applyChain(auth.LoginHandler(svc.Route, svc.SessionDuration), chain))
+			logout := withPage(applyChain(auth.LogoutHandler(svc.Route), chain))
+			if err := errors.Join(
+				vh.handle(svc.Route+"/login", login),
+				vh.handle(svc.Route+"/login/", login),
+				vh.handle(svc.Route+"/logout", logout),
+				vh.handle(svc.Route+"/logout/", logout),
+			); err != nil {
+				return nil, err
+			}
 			// protect all other endpoints under svc.Route
 			handler = auth.SessionMiddleware(svc.Route, svc.SessionDuration)(handler)
 		}
 
-		// ensure log directory exists
-		logDir := filepath.Dir(svc.Log)
-		if err := os.MkdirAll(logDir, 0o755); err != nil {
-			return nil, err
-		}
-		logFile, err := os.OpenFile(svc.Log, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o666)
+		handler = applyChain(handler, chain)
+
+		logFile, err := rt.openLog(svc.Log)
 		if err != nil {
 			return nil, err
 		}
 		logger := log.New(logFile, "", 0)
 
 		// apply logging middleware for non-WS routes
-		if !isWS {
+		if kind != KindWebSocket {
 			handler = middleware.LoggingMiddleware(handler, logger, svc.Route)
 		}
 
-		// register main handler (with and without trailing slash)
-		mux.Handle(svc.Route, handler)
-		mux.Handle(svc.Route+"/", handler)
+		handler = withPage(handler)
+
+		// services sharing a route are told apart by their match predicates
+		group, err := vh.group(svc.Route)
+		if err != nil {
+			return nil, err
+		}
+		group.add(svc.Priority, svc.Match, handler)
+		if svc.Default {
+			vh.def = group
+		}
+
+		log.Printf("Registered route %s%s", svc.Host, svc.Route)
+	}
+
+	if cfg.Admin != nil {
+		if err := rt.mountAdmin(*cfg.Admin); err != nil {
+			return nil, err
+		}
+	}
+
+	rt.keep()
+	return rt, nil
+}
 
-		log.Printf("Registered route %s", svc.Route)
+// buildPool creates the load balancer of a service, or of one of its split
+// groups, with the pool features the service enables. Health probes use
+// probeTransport, so they are not bound by the service timeouts.
+func (rt *Router) buildPool(svc config.ServiceConfig, group string, ups []config.Upstream, transport, probeTransport http.RoundTripper, budget *proxy.RetryBudget) (*proxy.LoadBalancer, error) {
+	lb, err := proxy.BuildLoadBalancer(ups, svc.LB, transport)
+	if err != nil {
+		return nil, err
 	}
+	rt.pools = append(rt.pools, poolInfo{id: serviceID(svc), host: svc.Host, route: svc.Route, group: group, lb: lb})
+	rt.closers = append(rt.closers, lb)
 
-	return mux, nil
+	if svc.Outlier != nil {
+		lb.EnableOutlierDetection(*svc.Outlier)
+	}
+	if svc.Sticky != nil {
+		lb.EnableSticky(*svc.Sticky, svc.Route)
+	}
+	if svc.Retry != nil {
+		lb.EnableRetries(*svc.Retry, budget)
+	}
+	if svc.CircuitBreaker != nil {
+		lb.EnableCircuitBreakers(*svc.CircuitBreaker)
+	}
+	if prev := previousPool(svc, group); prev != nil {
+		lb.AdoptState(prev, svc.HealthCheck != nil)
+	}
+	if svc.HealthCheck != nil {
+		lb.StartHealthChecks(*svc.HealthCheck, probeTransport)
+	}
+	return lb, nil
 }
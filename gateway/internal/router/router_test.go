@@ -0,0 +1,62 @@
+package router
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/RafaelZelak/gateway/internal/config"
+)
+
+func TestNewRouterReportsPatternConflicts(t *testing.T) {
+	dir := t.TempDir()
+	// skips config.Validate, which rejects this config up front
+	cfg := &config.Config{Services: []config.ServiceConfig{
+		{Route: "/{id}", Target: "http://127.0.0.1:1", Login: true, SessionDuration: 60, Log: filepath.Join(dir, "id.log")},
+		{Route: "/x", Target: "http://127.0.0.1:1", Log: filepath.Join(dir, "x.log")},
+	}}
+	rt, err := NewRouter(cfg)
+	if err == nil {
+		rt.Close()
+		t.Fatal("NewRouter accepted conflicting patterns")
+	}
+	if !strings.Contains(err.Error(), "conflicts") {
+		t.Errorf("err = %v, want the ServeMux conflict", err)
+	}
+}
+
+func TestCloseReleasesLogFiles(t *testing.T) {
+	dir := t.TempDir()
+	kept, removed := filepath.Join(dir, "kept.log"), filepath.Join(dir, "removed.log")
+	service := func(route, log string) config.ServiceConfig {
+		return config.ServiceConfig{Route: route, Target: "http://127.0.0.1:1", Log: log}
+	}
+	open := func(path string) bool {
+		logFilesMutex.Lock()
+		defer logFilesMutex.Unlock()
+		_, ok := logFiles[path]
+		return ok
+	}
+
+	old, err := NewRouter(&config.Config{Services: []config.ServiceConfig{service("/a", kept), service("/b", removed)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a reload that drops /b: the new router is built before the old one closes
+	next, err := NewRouter(&config.Config{Services: []config.ServiceConfig{service("/a", kept)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.Close()
+	if !open(kept) {
+		t.Error("log file still used by the new router was closed")
+	}
+	if open(removed) {
+		t.Error("log file of the removed service is still open")
+	}
+
+	next.Close()
+	if open(kept) {
+		t.Error("log file still open after its last router closed")
+	}
+}
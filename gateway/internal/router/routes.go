@@ -0,0 +1,142 @@
+package router
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/RafaelZelak/gateway/internal/config"
+)
+
+// Handler kinds reported in the route table.
+const (
+	KindTemplate     = "template"
+	KindWebSocket    = "websocket"
+	KindLoadBalancer = "load balancer"
+	KindProxy        = "proxy"
+	KindSplit        = "split"
+)
+
+// RouteInfo describes how NewRouter resolves a single service.
+type RouteInfo struct {
+	Host       string
+	Route      string
+	Default    bool
+	Match      string
+	Priority   int
+	Kind       string
+	Strategy   string
+	Targets    []string
+	Login      bool
+	Log        string
+	Middleware []string
+}
+
+// handlerKind classifies a service the same way NewRouter builds its handler.
+func handlerKind(svc config.ServiceConfig) string {
+	if svc.TemplateDir != "" {
+		return KindTemplate
+	}
+	ups := svc.Upstreams()
+	switch {
+	case svc.Split != nil:
+		return KindSplit
+	case len(ups) > 0 && config.IsWebSocketURL(ups[0].URL):
+		return KindWebSocket
+	case len(ups) > 1 || len(ups) == 1 && needsPool(svc):
+		return KindLoadBalancer
+	default:
+		return KindProxy
+	}
+}
+
+// describeUpstream renders an upstream with its non-default settings.
+func describeUpstream(up config.Upstream) string {
+	var opts []string
+	if up.Name != "" && !strings.Contains(up.URL, "//"+up.Name) {
+		opts = append(opts, "name="+up.Name)
+	}
+	if up.Weight != nil && *up.Weight != 1 {
+		opts = append(opts, fmt.Sprintf("weight=%d", *up.Weight))
+	}
+	if up.MaxConns > 0 {
+		opts = append(opts, fmt.Sprintf("maxConns=%d", up.MaxConns))
+	}
+	if up.Backup {
+		opts = append(opts, "backup")
+	}
+	if len(opts) == 0 {
+		return up.URL
+	}
+	return up.URL + " (" + strings.Join(opts, " ") + ")"
+}
+
+// needsPool reports whether a single-backend service uses settings that only
+// the load balancer implements (connection cap, health tracking, affinity,
+// retries, circuit breaking).
+func needsPool(svc config.ServiceConfig) bool {
+	return svc.Upstreams()[0].MaxConns > 0 ||
+		svc.HealthCheck != nil ||
+		svc.Outlier != nil ||
+		svc.LB != nil ||
+		svc.Sticky != nil ||
+		svc.Retry != nil ||
+		svc.CircuitBreaker != nil
+}
+
+// Describe returns the resolved route table for cfg without mounting anything.
+func Describe(cfg *config.Config) []RouteInfo {
+	routes := make([]RouteInfo, 0, len(cfg.Services))
+	for _, svc := range cfg.Services {
+		info := RouteInfo{
+			Host:       svc.Host,
+			Route:      svc.Route,
+			Default:    svc.Default,
+			Match:      svc.Match.String(),
+			Priority:   svc.Priority,
+			Kind:       handlerKind(svc),
+			Login:      svc.Login,
+			Log:        svc.Log,
+			Middleware: chainNames(middlewareChain(cfg.MiddlewareFor(svc))),
+		}
+		if info.Kind == KindLoadBalancer || info.Kind == KindSplit {
+			info.Strategy = config.StrategyRandom
+			if svc.LB != nil {
+				info.Strategy = svc.LB.Strategy
+				if svc.LB.HashKey != "" {
+					info.Strategy += "(" + svc.LB.HashKey + ")"
+				}
+			}
+			if svc.Sticky != nil {
+				info.Strategy += ", sticky"
+			}
+			if svc.Retry != nil {
+				info.Strategy += ", retry"
+			}
+			if svc.CircuitBreaker != nil {
+				info.Strategy += ", breaker"
+			}
+		}
+		switch info.Kind {
+		case KindTemplate:
+			info.Targets = []string{svc.TemplateDir}
+		case KindSplit:
+			total := 0
+			for _, g := range svc.Split.Groups {
+				total += g.Weight
+			}
+			for _, g := range svc.Split.Groups {
+				var ups []string
+				for _, up := range g.Upstreams() {
+					ups = append(ups, describeUpstream(up))
+				}
+				info.Targets = append(info.Targets, fmt.Sprintf("[%s %d%%] %s", g.Name, g.Weight*100/max(total, 1), strings.Join(ups, " ")))
+			}
+		default:
+			for _, up := range svc.Upstreams() {
+				info.Targets = append(info.Targets, describeUpstream(up))
+			}
+		}
+		routes = append(routes, info)
+	}
+	return routes
+}
@@ -0,0 +1,193 @@
+package router
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/RafaelZelak/gateway/internal/auth"
+	"github.com/RafaelZelak/gateway/internal/config"
+	"github.com/RafaelZelak/gateway/internal/proxy"
+)
+
+// SplitGroupHeader tells the backend which split group a request was sent to.
+const SplitGroupHeader = "X-Split-Group"
+
+// splitter sends each client to one upstream group of a split service.
+type splitter struct {
+	host, route string
+	names       []string
+	base        []int // configured weights
+	weights     atomic.Pointer[[]int]
+	weightsMu   sync.Mutex // serializes admin changes
+	groups      []http.Handler
+	key         func(*http.Request) string // nil: logged-in user, then client IP
+	overrides   []splitOverride
+}
+
+// splitOverride is a compiled config.SplitOverride.
+type splitOverride struct {
+	group int
+	pred  *predicate
+	users map[string]bool // nil: any user
+}
+
+// runtimeWeights keeps weights changed through the admin endpoint across
+// config reloads, until the configured weights of the route change.
+var (
+	runtimeWeightsMu sync.Mutex
+	runtimeWeights   = make(map[string]weightChange) // host + route
+)
+
+type weightChange struct {
+	names   []string
+	base    []int
+	weights []int
+}
+
+func newSplitter(svc config.ServiceConfig, groups []http.Handler) *splitter {
+	sp := svc.Split
+	s := &splitter{host: svc.Host, route: svc.Route, base: sp.Weights(), groups: groups}
+	index := make(map[string]int, len(sp.Groups))
+	for i, g := range sp.Groups {
+		s.names = append(s.names, g.Name)
+		index[g.Name] = i
+	}
+	if sp.Key != "" {
+		s.key = proxy.KeyFunc(sp.Key)
+	}
+	for _, o := range sp.Overrides {
+		so := splitOverride{group: index[o.Group], pred: compilePredicate(o.Match)}
+		if len(o.Users) > 0 {
+			so.users = make(map[string]bool, len(o.Users))
+			for _, u := range o.Users {
+				so.users[u] = true
+			}
+		}
+		s.overrides = append(s.overrides, so)
+	}
+
+	weights := s.base
+	runtimeWeightsMu.Lock()
+	if c, ok := runtimeWeights[s.id()]; ok {
+		if equalInts(c.base, s.base) && strings.Join(c.names, "\x00") == strings.Join(s.names, "\x00") {
+			weights = c.weights
+		} else {
+			delete(runtimeWeights, s.id())
+		}
+	}
+	runtimeWeightsMu.Unlock()
+	s.weights.Store(&weights)
+	return s
+}
+
+func (s *splitter) id() string { return strings.ToLower(s.host) + s.route }
+
+func (s *splitter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g := s.pick(r)
+	r.Header.Set(SplitGroupHeader, s.names[g])
+	s.groups[g].ServeHTTP(w, r)
+}
+
+// pick returns the group of the request: the first matching override, or
+// the group the client's key hashes into under the current weights.
+func (s *splitter) pick(r *http.Request) int {
+	user, _ := auth.Username(r)
+	for _, o := range s.overrides {
+		if o.pred.matches(r) && (o.users == nil || o.users[user]) {
+			return o.group
+		}
+	}
+
+	key := user
+	if s.key != nil {
+		key = s.key(r)
+	}
+	if key == "" {
+		key = proxy.KeyFunc("ip")(r)
+	}
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	// a fixed point per client, so a weight change only moves the clients
+	// in the shifted range
+	point := h.Sum64() % 10000
+
+	weights := *s.weights.Load()
+	total := 0
+	for _, w := range weights {
+		total += w
+	}
+	acc := 0
+	for i, w := range weights {
+		acc += w
+		if point*uint64(total) < uint64(acc)*10000 {
+			return i
+		}
+	}
+	return len(weights) - 1
+}
+
+// setWeights changes the weights of the named groups; the others keep theirs.
+func (s *splitter) setWeights(changes map[string]int) error {
+	s.weightsMu.Lock()
+	defer s.weightsMu.Unlock()
+
+	weights := append([]int(nil), *s.weights.Load()...)
+	for name, w := range changes {
+		i := indexOf(s.names, name)
+		if i < 0 {
+			return fmt.Errorf("unknown group %q", name)
+		}
+		if w < 0 {
+			return fmt.Errorf("weight of %q must not be negative", name)
+		}
+		weights[i] = w
+	}
+	total := 0
+	for _, w := range weights {
+		total += w
+	}
+	if total == 0 {
+		return fmt.Errorf("at least one group needs a positive weight")
+	}
+
+	s.weights.Store(&weights)
+	runtimeWeightsMu.Lock()
+	runtimeWeights[s.id()] = weightChange{names: s.names, base: s.base, weights: weights}
+	runtimeWeightsMu.Unlock()
+	log.Printf("[SPLIT] %s%s weights set to %s", s.host, s.route, s.describe(weights))
+	return nil
+}
+
+func (s *splitter) describe(weights []int) string {
+	parts := make([]string, len(weights))
+	for i, w := range weights {
+		parts[i] = fmt.Sprintf("%s=%d", s.names[i], w)
+	}
+	return strings.Join(parts, " ")
+}
+
+func indexOf(names []string, name string) int {
+	for i, n := range names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
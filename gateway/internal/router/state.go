@@ -0,0 +1,66 @@
+package router
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/RafaelZelak/gateway/internal/config"
+	"github.com/RafaelZelak/gateway/internal/proxy"
+)
+
+// What a router learns at runtime (backend health, ejections, circuits and
+// the buckets of the rate, connection and queue limits) is handed to the
+// router that replaces it on reload, so a config edit neither sends traffic
+// back to a dead backend nor resets every client's limits.
+var (
+	keptMu    sync.Mutex
+	keptPools = make(map[string]*proxy.LoadBalancer) // serviceID + group
+	keptSteps = make(map[string]step)                // serviceID + step name
+)
+
+// serviceID identifies a service across reloads: services sharing a route
+// are told apart by their priority and match predicate.
+func serviceID(svc config.ServiceConfig) string {
+	match, _ := json.Marshal(svc.Match)
+	return strings.ToLower(svc.Host) + svc.Route + "\x00" + strconv.Itoa(svc.Priority) + string(match)
+}
+
+// previousPool returns the balancer the active router uses for the same
+// pool, if any.
+func previousPool(svc config.ServiceConfig, group string) *proxy.LoadBalancer {
+	keptMu.Lock()
+	defer keptMu.Unlock()
+	return keptPools[serviceID(svc)+"\x00"+group]
+}
+
+// keepChain swaps every stateful step of chain for the one the active
+// router uses for the same service and settings.
+func (rt *Router) keepChain(svc config.ServiceConfig, chain []step) []step {
+	keptMu.Lock()
+	defer keptMu.Unlock()
+	for i, s := range chain {
+		if !s.stateful {
+			continue
+		}
+		key := serviceID(svc) + "\x00" + s.name
+		if prev, ok := keptSteps[key]; ok {
+			chain[i] = prev
+		}
+		rt.steps[key] = chain[i]
+	}
+	return chain
+}
+
+// keep publishes the state of rt, which is about to become the active
+// router; state of services no longer configured is dropped.
+func (rt *Router) keep() {
+	pools := make(map[string]*proxy.LoadBalancer, len(rt.pools))
+	for _, p := range rt.pools {
+		pools[p.id+"\x00"+p.group] = p.lb
+	}
+	keptMu.Lock()
+	keptPools, keptSteps = pools, rt.steps
+	keptMu.Unlock()
+}
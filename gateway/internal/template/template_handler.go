@@ -5,6 +5,8 @@ import (
 	"net/http"
 	"path/filepath"
 	"strings"
+
+	"github.com/RafaelZelak/gateway/pkg/httperr"
 )
 
 // TemplateHandler holds parsed templates e configurações de rota
@@ -45,10 +47,10 @@ func (th *TemplateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if th.templates.Lookup(tmplName) == nil {
-		http.NotFound(w, r)
+		httperr.Write(w, r, http.StatusNotFound, httperr.CodeNotFound, "page not found")
 		return
 	}
 	if err := th.templates.ExecuteTemplate(w, tmplName, nil); err != nil {
-		http.Error(w, "Template rendering error", http.StatusInternalServerError)
+		httperr.Write(w, r, http.StatusInternalServerError, httperr.CodeInternal, "template rendering error")
 	}
 }
@@ -0,0 +1,86 @@
+// Package httperr writes the errors generated by the gateway itself, as a
+// JSON envelope or, for browser routes with an error page, as HTML.
+package httperr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+// Error codes of the JSON envelope.
+const (
+	CodeBadRequest       = "bad_request"
+	CodeUnauthorized     = "unauthorized"
+	CodeForbidden        = "forbidden"
+	CodeNotFound         = "not_found"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeRateLimited      = "rate_limited"
+	CodeOverloaded       = "overloaded"
+	CodeInternal         = "internal_error"
+	CodeBadGateway       = "bad_gateway"
+	CodeNoUpstream       = "no_upstream"
+	CodeCircuitOpen      = "circuit_open"
+	CodeUpstreamTimeout  = "upstream_timeout"
+)
+
+// RequestIDHeader carries the ID that ties an error to the gateway logs.
+const RequestIDHeader = "X-Request-ID"
+
+// Envelope is the JSON body of every gateway error.
+type Envelope struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// Page is the data passed to an HTML error page template.
+type Page struct {
+	Status     int
+	StatusText string
+	Envelope
+}
+
+type pageKey struct{}
+
+// WithPage renders errors of the wrapped handler with tpl when the client
+// asks for HTML.
+func WithPage(next http.Handler, tpl *template.Template) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), pageKey{}, tpl)))
+	})
+}
+
+// Write sends an error with the given status, code and message. Routes with
+// an error page get HTML when the client accepts it, everything else JSON.
+func Write(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	env := Envelope{Code: code, Message: message, RequestID: r.Header.Get(RequestIDHeader)}
+	h := w.Header()
+	// the failed upstream's headers do not describe this body
+	h.Del("Content-Length")
+	h.Del("Content-Encoding")
+
+	if tpl, ok := r.Context().Value(pageKey{}).(*template.Template); ok && WantsHTML(r) {
+		var buf bytes.Buffer
+		err := tpl.Execute(&buf, Page{Status: status, StatusText: http.StatusText(status), Envelope: env})
+		if err == nil {
+			h.Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(status)
+			w.Write(buf.Bytes())
+			return
+		}
+	}
+
+	body, _ := json.Marshal(env)
+	h.Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(body, '\n'))
+}
+
+// WantsHTML reports whether the request comes from a browser.
+func WantsHTML(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
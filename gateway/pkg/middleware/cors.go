@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+)
+
+// CORS responde preflights e adiciona os headers Access-Control-* para as
+// origens permitidas. Listas vazias usam os mesmos valores de WrapMux.
+func CORS(origins, methods, headers []string) func(http.Handler) http.Handler {
+	if len(origins) == 0 {
+		origins = []string{"*"}
+	}
+	if len(methods) == 0 {
+		methods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
+	}
+	if len(headers) == 0 {
+		headers = []string{"Content-Type", "Authorization"}
+	}
+	allowMethods := strings.Join(methods, ",")
+	allowHeaders := strings.Join(headers, ",")
+
+	allowed := make(map[string]bool, len(origins))
+	for _, o := range origins {
+		allowed[o] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			switch {
+			case allowed["*"]:
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			case origin != "" && allowed[origin]:
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Add("Vary", "Origin")
+			default:
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Methods", allowMethods)
+			w.Header().Set("Access-Control-Allow-Headers", allowHeaders)
+
+			// preflight
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -2,6 +2,8 @@ package middleware
 
 import (
 	"net/http"
+
+	"github.com/RafaelZelak/gateway/pkg/httperr"
 )
 
 // WrapMux applies CORS, security headers (inline scripts & styles) and JSON-404 to a ServeMux.
@@ -29,9 +31,7 @@ func WrapMux(mux *http.ServeMux) http.Handler {
 		// Detect 404
 		handler, pattern := mux.Handler(r)
 		if pattern == "" {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusNotFound)
-			w.Write([]byte(`{"error":"resource not found"}`))
+			httperr.Write(w, r, http.StatusNotFound, httperr.CodeNotFound, "resource not found")
 			return
 		}
 
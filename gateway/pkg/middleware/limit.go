@@ -1,24 +1,31 @@
 package middleware
 
 import (
+	"net"
 	"net/http"
 	"sync"
 
+	"github.com/RafaelZelak/gateway/pkg/httperr"
 	"golang.org/x/time/rate"
 )
 
-var (
-	limitersMutex   = &sync.Mutex{}
-	limiters        = make(map[string]*rate.Limiter)
-	semaphoresMutex = &sync.Mutex{}
-	connSemaphores  = make(map[string]chan struct{})
-)
+// clientIP extrai o IP do cliente de RemoteAddr, sem a porta.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
 
 // RateLimit aplica um leaky‐bucket por IP (rps, burst).
 func RateLimit(rps float64, burst int) func(http.Handler) http.Handler {
+	limitersMutex := &sync.Mutex{}
+	limiters := make(map[string]*rate.Limiter)
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			key := r.RemoteAddr
+			key := clientIP(r)
 
 			limitersMutex.Lock()
 			lim, ok := limiters[key]
@@ -30,7 +37,7 @@ func RateLimit(rps float64, burst int) func(http.Handler) http.Handler {
 
 			if !lim.Allow() {
 				w.Header().Set("Retry-After", "1")
-				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				httperr.Write(w, r, http.StatusTooManyRequests, httperr.CodeRateLimited, "rate limit exceeded")
 				return
 			}
 			next.ServeHTTP(w, r)
@@ -40,9 +47,12 @@ func RateLimit(rps float64, burst int) func(http.Handler) http.Handler {
 
 // ConnLimit aplica semáforo por IP para limitar conexões simultâneas.
 func ConnLimit(limit int) func(http.Handler) http.Handler {
+	semaphoresMutex := &sync.Mutex{}
+	connSemaphores := make(map[string]chan struct{})
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			key := r.RemoteAddr
+			key := clientIP(r)
 
 			semaphoresMutex.Lock()
 			sem, ok := connSemaphores[key]
@@ -57,7 +67,7 @@ func ConnLimit(limit int) func(http.Handler) http.Handler {
 				defer func() { <-sem }()
 				next.ServeHTTP(w, r)
 			default:
-				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+				httperr.Write(w, r, http.StatusServiceUnavailable, httperr.CodeOverloaded, "too many concurrent requests")
 			}
 		})
 	}
@@ -73,7 +83,7 @@ func QueueLimit(size int) func(http.Handler) http.Handler {
 				defer func() { <-queue }()
 				next.ServeHTTP(w, r)
 			default:
-				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+				httperr.Write(w, r, http.StatusServiceUnavailable, httperr.CodeOverloaded, "request queue is full")
 			}
 		})
 	}
@@ -4,6 +4,8 @@ import (
 	"log"
 	"net/http"
 	"time"
+
+	"github.com/RafaelZelak/gateway/pkg/httperr"
 )
 
 // LoggingResponseWriter wraps http.ResponseWriter para capturar status code
@@ -18,19 +20,20 @@ func (lrw *LoggingResponseWriter) WriteHeader(code int) {
 	lrw.ResponseWriter.WriteHeader(code)
 }
 
-// LoggingMiddleware registra timestamp, IP, método, URI, status e latência
+// LoggingMiddleware registra timestamp, IP, método, URI, status, latência e request ID
 func LoggingMiddleware(next http.Handler, logger *log.Logger, routeName string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 		lrw := &LoggingResponseWriter{ResponseWriter: w, StatusCode: http.StatusOK}
 		next.ServeHTTP(lrw, r)
-		logger.Printf("[%s] %s %s %s -> %d %v",
+		logger.Printf("[%s] %s %s %s -> %d %v %s",
 			time.Now().Format(time.RFC3339),
 			r.RemoteAddr,
 			r.Method,
 			r.RequestURI,
 			lrw.StatusCode,
 			time.Since(start),
+			r.Header.Get(httperr.RequestIDHeader),
 		)
 	})
 }